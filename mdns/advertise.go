@@ -1,84 +1,540 @@
 package mdns
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/grandcat/zeroconf"
 )
 
+// advertisedService is a service type announced on a port.
+type advertisedService struct {
+	service string
+	port    int
+}
+
+// registration is the running announcement of one service type.
+type registration struct {
+	advertisedService
+	server serviceServer
+}
+
+// serviceServer announces a registered service, see zeroconf.Server.
+type serviceServer interface {
+	SetText(text []string)
+	Shutdown()
+}
+
+// serviceRegistrar registers an instance of a service on the interfaces,
+// announcing it with the TTL.
+type serviceRegistrar func(instance, service, domain string, port int, text []string, ifaces []net.Interface, ttl uint32) (serviceServer, error)
+
+// registerService registers a service with zeroconf.
+func registerService(instance, service, domain string, port int, text []string, ifaces []net.Interface, ttl uint32) (serviceServer, error) {
+	server, err := zeroconf.Register(instance, service, domain, port, text, ifaces)
+	if err != nil {
+		return nil, err
+	}
+	server.TTL(ttl)
+	return server, nil
+}
+
 type MdnsAdvertise struct {
-	advertise    bool
-	instanceName string
-	service  	 string
-	domain   	 string
-	port      	 int
-	ttl 		 uint32
-	txtEntries []string
-	ifaceBindSubnet *net.IPNet // subnet to search on
+	advertise        bool
+	baseInstanceName string // configured name, instanceName may add a suffix on conflicts
+	instanceName     string
+	services         []advertisedService // announced together, sharing the TXT record
+	domain           string
+	ttl              uint32
+	nodeID           string
+	mesh             string
+	txtEntries       []string
+	ifaceSelector    interfaceSelector // interfaces to advertise on
+
+	zones       []string       // zones served by the server block
+	listen      []string       // addresses the server block listens on
+	subnets     []netip.Prefix // subnets this node answers reverse lookups for
+	autoSubnets bool           // derive subnets from the advertised interfaces
+
+	signingKey ed25519.PrivateKey // signs the advertisement when set
+
+	healthCheck     HealthCheck // withdraws the advertisement while failing
+	healthInterval  time.Duration
+	healthFailures  int
+	healthSuccesses int
+
+	probe     serviceProber    // finds other responders for the instance name
+	registrar serviceRegistrar // announces the services
+
+	drain time.Duration // kept answering after the goodbye when CoreDNS exits
+
+	hostRecords map[string][]net.IP // A and AAAA records published in .local
 
-	server 		*zeroconf.Server
+	mutex         sync.Mutex
+	started       bool
+	withdrawn     bool           // by the health check
+	registrations []registration // nil while withdrawn or waiting for the bind subnet
+	ifaceState    string         // interfaces and addresses the services were registered with
+	stopCh        chan struct{}
+	healthStopCh  chan struct{}
+	watchStopCh   chan struct{}
+	updateTimer   *time.Timer // pending publication of changed TXT entries
+	hosts         *hostResponder
+	hostsState    string // interfaces and addresses the host responder runs on
 }
 
 func NewMdnsAdvertise(instanceName, service string, port int, ttl uint32) *MdnsAdvertise {
 	return &MdnsAdvertise{
-		advertise:     true,
-		instanceName:  instanceName,
-		service:  	   service,
-		domain:        DefaultDomain, // always use local. Technically this may be different, but resolvers dont generally respect other values.
-		port:          port,
-		ttl: 		   ttl,
+		advertise:        true,
+		baseInstanceName: instanceName,
+		instanceName:     instanceName,
+		probe:            probeService,
+		registrar:        registerService,
+		services:         []advertisedService{{service: service, port: port}},
+		domain:           DefaultDomain, // always use local. Technically this may be different, but resolvers dont generally respect other values.
+		ttl:              ttl,
 	}
 }
 
+// AddService announces another service type, e.g. _dns._tcp next to
+// _dns._udp, with the same instance name and TXT record.
+func (m *MdnsAdvertise) AddService(service string, port int) {
+	m.services = append(m.services, advertisedService{service: service, port: port})
+}
+
+// BindToSubnet advertises on the interfaces with an address in subnet. Can be
+// combined with BindToInterface; an interface matching either is used.
 func (m *MdnsAdvertise) BindToSubnet(subnet *net.IPNet) {
-	m.ifaceBindSubnet = subnet
+	if subnet != nil {
+		m.ifaceSelector.subnets = append(m.ifaceSelector.subnets, subnet)
+	}
+}
+
+// BindToInterface advertises on the interfaces whose name matches pattern, a
+// name or a glob such as "wg*".
+func (m *MdnsAdvertise) BindToInterface(pattern string) {
+	m.ifaceSelector.names = append(m.ifaceSelector.names, pattern)
+}
+
+// ExcludeInterface never advertises on the interfaces whose name matches
+// pattern, a name or a glob.
+func (m *MdnsAdvertise) ExcludeInterface(pattern string) {
+	m.ifaceSelector.excludes = append(m.ifaceSelector.excludes, pattern)
+}
+
+// SetMesh sets the mesh namespace published in the TXT record. Forwarders
+// only use peers in their own namespace.
+func (m *MdnsAdvertise) SetMesh(mesh string) {
+	m.mesh = mesh
+}
+
+// SetNodeID sets the node ID published in the TXT record.
+func (m *MdnsAdvertise) SetNodeID(nodeID string) {
+	m.nodeID = nodeID
+}
+
+// SetZones sets the zones published in the TXT record.
+func (m *MdnsAdvertise) SetZones(zones []string) {
+	m.zones = zones
+}
+
+// SetListenAddrs sets the listen addresses published in the TXT record.
+func (m *MdnsAdvertise) SetListenAddrs(addrs []string) {
+	m.listen = addrs
+}
+
+// SetSubnets sets the subnets published in the TXT record. Forwarders route
+// reverse lookups for addresses in these subnets to this node.
+func (m *MdnsAdvertise) SetSubnets(subnets []netip.Prefix) {
+	m.subnets = subnets
+}
+
+// AutoSubnets derives the published subnets from the addresses of the
+// advertised interfaces each time advertising starts.
+func (m *MdnsAdvertise) AutoSubnets() {
+	m.autoSubnets = true
+}
+
+// SignWith signs the advertisement with an Ed25519 key. The signature covers
+// the instance name, port, node ID and a timestamp, and is refreshed every
+// SignatureRefreshInterval while advertising.
+func (m *MdnsAdvertise) SignWith(key ed25519.PrivateKey) {
+	m.signingKey = key
+}
+
+// AddTxt adds a raw TXT entry, e.g. "role=prod" or a boolean "flag". An
+// entry with the same key is replaced. The same validation as SetTxt applies.
+func (m *MdnsAdvertise) AddTxt(entry string) error {
+	key, value, _ := strings.Cut(entry, "=")
+	if err := validateTxtEntry(key, value); err != nil {
+		return err
+	}
+	return m.storeTxt(key, entry)
+}
+
+// SetTxt sets the value of a TXT key, replacing the current value if the key
+// is already published. Keys published by the mesh itself are reserved.
+// Changes to a running advertisement are published after TxtUpdateDelay so
+// that a burst of changes is announced once.
+func (m *MdnsAdvertise) SetTxt(key, value string) error {
+	if err := validateTxtEntry(key, value); err != nil {
+		return err
+	}
+	return m.storeTxt(key, txtEntry(key, value))
+}
+
+// storeTxt publishes entry in place of the current entry of key, unless
+// the complete TXT record would become too large.
+func (m *MdnsAdvertise) storeTxt(key, entry string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entries := m.txtEntries
+	m.txtEntries = replaceTxt(entries, key, entry)
+	if err := validateTxtRecord(m.text(m.services[0].port)); err != nil {
+		m.txtEntries = entries
+		return err
+	}
+	m.scheduleTextUpdate()
+	return nil
+}
+
+// RemoveTxt removes a TXT key. Like SetTxt, the change is published after
+// TxtUpdateDelay.
+func (m *MdnsAdvertise) RemoveTxt(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.txtEntries = removeTxt(m.txtEntries, key)
+	m.scheduleTextUpdate()
+}
+
+// scheduleTextUpdate publishes the TXT record of a running advertisement
+// after TxtUpdateDelay, unless an update is already pending. The caller must
+// hold the mutex.
+func (m *MdnsAdvertise) scheduleTextUpdate() {
+	if m.registrations == nil || m.updateTimer != nil {
+		return
+	}
+	m.updateTimer = time.AfterFunc(TxtUpdateDelay, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.updateTimer = nil
+		m.publishText()
+	})
+}
+
+// publishText announces the current TXT record of every registered service.
+// The caller must hold the mutex.
+func (m *MdnsAdvertise) publishText() {
+	for _, reg := range m.registrations {
+		reg.server.SetText(m.text(reg.port))
+	}
+}
+
+// SetHealthCheck runs check every interval while advertising. The
+// advertisement is withdrawn with a goodbye after the given number of
+// consecutive failures, and announced again after the given number of
+// consecutive successes.
+func (m *MdnsAdvertise) SetHealthCheck(check HealthCheck, interval time.Duration, failures, successes int) {
+	m.healthCheck = check
+	m.healthInterval = interval
+	m.healthFailures = failures
+	m.healthSuccesses = successes
+}
+
+// key identifies the configuration of the advertisement.
+func (m *MdnsAdvertise) key() string {
+	return fmt.Sprintf("%s|%v|%s|%d|%s|%s|%q|%q|%q|%v|%x|%v|%t|%v|%s|%d|%d|%v|%v",
+		m.baseInstanceName, m.services, m.domain, m.ttl, m.nodeID, m.mesh, m.txtEntries, m.zones, m.listen,
+		&m.ifaceSelector, []byte(m.signingKey), m.subnets, m.autoSubnets,
+		m.healthCheck, m.healthInterval, m.healthFailures, m.healthSuccesses, m.hostRecords, m.drain)
+}
+
+// SetDrain makes the advertisement send its goodbye as soon as CoreDNS is
+// asked to exit, and keeps the servers answering for the drain period
+// before they shut down, see drainOnShutdown.
+func (m *MdnsAdvertise) SetDrain(drain time.Duration) {
+	m.drain = drain
 }
 
-func (m *MdnsAdvertise) AddTxt(txtEntry string) {
-	m.txtEntries = append(m.txtEntries, txtEntry)
+// advertising reports whether the advertisement has been started. A started
+// advertisement may be withdrawn while its health check fails.
+func (m *MdnsAdvertise) advertising() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.started
+}
 
-	if (m.server != nil) {
-		m.server.SetText(m.txtEntries)
+// text builds the full TXT record of the service on port: the configured
+// entries followed by the entries the mesh itself relies on.
+func (m *MdnsAdvertise) text(port int) []string {
+	text := append([]string{}, m.txtEntries...)
+	if m.mesh != "" {
+		text = append(text, txtEntry(TxtMesh, m.mesh))
+	}
+	if m.nodeID != "" {
+		text = append(text, txtEntry(TxtNodeID, m.nodeID))
+	}
+	if len(m.zones) > 0 {
+		text = append(text, txtEntry(TxtZones, strings.Join(m.zones, ",")))
+	}
+	if len(m.listen) > 0 {
+		text = append(text, txtEntry(TxtListen, strings.Join(m.listen, ",")))
 	}
+	if len(m.subnets) > 0 {
+		text = append(text, txtEntry(TxtSubnets, formatSubnets(m.subnets)))
+	}
+	if m.signingKey != nil {
+		text = append(text, signAdvertisement(m.signingKey, m.instanceName, port, m.nodeID, time.Now().Unix())...)
+	}
+	return text
 }
 
 func (m *MdnsAdvertise) StartAdvertise() error {
-	if (m.server != nil) {
+	if m.advertising() {
 		m.StopAdvertise()
 	}
 
-	log.Infof("Start advertising...\n    Instance: %s\n    Service: %s\n    Port: %d\n    TTL: %d", m.instanceName, m.service, m.port, m.ttl)
-
-	var ifaces []net.Interface
-	if m.ifaceBindSubnet != nil {
-		foundIfaces, err := FindInterfacesForSubnet(*m.ifaceBindSubnet)
-		if err != nil || len(foundIfaces) == 0 {
-			log.Errorf("Failed to find interface for '%s'\n", m.ifaceBindSubnet.String())
-		} else {
-			ifaces = foundIfaces
+	for _, s := range m.services {
+		if s.port < 1 || s.port > 65535 {
+			return fmt.Errorf("refusing to advertise %s on invalid port %d", s.service, s.port)
 		}
 	}
 
-	server, err := zeroconf.Register(
-		m.instanceName, 
-		m.service, 
-		m.domain, 
-		m.port, 
-		m.txtEntries,
-		ifaces,
-	)
-	server.TTL(m.ttl) // refresh every 2 mins
+	// Probing takes a while, so it runs without holding the mutex. A previous
+	// advertisement of the same services already holds the free instance
+	// name, which may carry a suffix after a conflict.
+	ifaces, ifaceErr := m.advertisedInterfaces()
+	peers := map[advertisedService][]peerInstance(nil)
+	adopted, adoptedHosts := []registration(nil), (*hostResponder)(nil)
+	if ifaceErr == nil {
+		peers = m.probePeers(ifaces)
+		adopted, adoptedHosts = m.takeOver(freeInstanceName(m.baseInstanceName, peers), interfaceState(ifaces))
+	}
 
-	if err != nil {
-		log.Errorf("Error staring advertisement: %s", err)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	services := []string{}
+	for _, s := range m.services {
+		services = append(services, fmt.Sprintf("%s on port %d", s.service, s.port))
+	}
+	log.Infof("Start advertising...\n    Instance: %s\n    Services: %s\n    TTL: %d", m.instanceName, strings.Join(services, ", "), m.ttl)
+
+	if errors.Is(ifaceErr, errNoBindInterface) {
+		log.Warningf("Waiting to advertise: %s", ifaceErr)
+	} else if ifaceErr != nil {
+		return ifaceErr
+	} else if err := m.registerAdopting(ifaces, peers, adopted); err != nil {
+		if adoptedHosts != nil {
+			adoptedHosts.stop()
+		}
 		return err
 	}
-	m.server = server
+	m.started = true
+	m.withdrawn = false
+	if adoptedHosts != nil {
+		m.hosts = adoptedHosts
+		m.hostsState = interfaceState(ifaces)
+	}
+
+	if ifaces, err := m.advertisedInterfaces(); err == nil {
+		m.updateHosts(ifaces, interfaceState(ifaces))
+	}
+
+	if m.signingKey != nil {
+		log.Infof("Signing advertisement with public key %s",
+			base64.StdEncoding.EncodeToString(m.signingKey.Public().(ed25519.PublicKey)))
+	}
+
+	m.watchStopCh = make(chan struct{})
+	go m.watchInterfaces(m.watchStopCh)
+	go m.watchConflicts(m.watchStopCh)
+
+	if m.healthCheck != nil {
+		if check, ok := m.healthCheck.(*dnsHealthCheck); ok {
+			check.addr = localDNSAddr(m.listen, m.services[0].port)
+		}
+		log.Infof("Checking health every %s with %s", m.healthInterval, m.healthCheck)
+		m.healthStopCh = make(chan struct{})
+		go m.healthLoop(m.healthCheck, m.healthInterval, newHysteresis(m.healthFailures, m.healthSuccesses), m.healthStopCh)
+	}
 	return nil
-} 
+}
+
+// register announces every service on the interfaces, under an instance
+// name none of the peers found by probing uses, see RFC 6762 section 9.
+// Either all services are registered or none. The caller must hold the
+// mutex.
+func (m *MdnsAdvertise) register(ifaces []net.Interface, peers map[advertisedService][]peerInstance) error {
+	return m.registerAdopting(ifaces, peers, nil)
+}
+
+// registerAdopting registers like register, but keeps announcing the
+// registrations handed over by a previous advertisement instead of
+// registering their services again, see handOver.
+func (m *MdnsAdvertise) registerAdopting(ifaces []net.Interface, peers map[advertisedService][]peerInstance, adopted []registration) error {
+	state := interfaceState(ifaces)
+	m.claimInstanceName(peers)
+
+	if m.autoSubnets {
+		subnets, err := interfaceSubnets(ifaces)
+		if err != nil {
+			log.Errorf("Failed to find subnets of the advertised interfaces: %s", err)
+		}
+		m.subnets = subnets
+	}
+
+	registrations := []registration{}
+	for _, s := range m.services {
+		text := m.text(s.port)
+		if err := validateTxtRecord(text); err != nil {
+			log.Errorf("Error starting advertisement: %s", err)
+			shutdownRegistrations(registrations)
+			shutdownRegistrations(adopted)
+			return err
+		}
+
+		if i := slices.IndexFunc(adopted, func(r registration) bool { return r.advertisedService == s }); i >= 0 {
+			adopted[i].server.SetText(text)
+			registrations = append(registrations, adopted[i])
+			adopted = slices.Delete(adopted, i, i+1)
+			continue
+		}
+
+		server, err := m.registrar(m.instanceName, s.service, m.domain, s.port, text, ifaces, m.ttl)
+		if err != nil {
+			log.Errorf("Error staring advertisement of %s: %s", s.service, err)
+			shutdownRegistrations(registrations)
+			shutdownRegistrations(adopted)
+			return err
+		}
+		registrations = append(registrations, registration{advertisedService: s, server: server})
+	}
+	m.registrations = registrations
+	m.ifaceState = state
+
+	if m.signingKey != nil {
+		m.stopCh = make(chan struct{})
+		go m.resignLoop(m.stopCh)
+	}
+	return nil
+}
+
+// shutdownRegistrations sends a goodbye for every registered service.
+func shutdownRegistrations(registrations []registration) {
+	for _, reg := range registrations {
+		reg.server.Shutdown()
+	}
+}
+
+// resignLoop periodically refreshes the signature timestamp so that peers
+// do not consider the advertisement stale.
+func (m *MdnsAdvertise) resignLoop(stopCh chan struct{}) {
+	ticker := time.NewTicker(SignatureRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			m.mutex.Lock()
+			m.publishText()
+			m.mutex.Unlock()
+		}
+	}
+}
 
 func (m *MdnsAdvertise) StopAdvertise() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	log.Infof("Stop advertising...")
-	m.server.Shutdown()
+	m.stop()
 }
 
+// stop stops the health check and sends a goodbye for the service. The
+// caller must hold the mutex.
+func (m *MdnsAdvertise) stop() {
+	m.started = false
+	if m.healthStopCh != nil {
+		close(m.healthStopCh)
+		m.healthStopCh = nil
+	}
+	if m.watchStopCh != nil {
+		close(m.watchStopCh)
+		m.watchStopCh = nil
+	}
+	m.stopHosts()
+	m.unregister()
+}
+
+// unregister sends a goodbye for every service, so that peers drop them from
+// their caches. The caller must hold the mutex.
+func (m *MdnsAdvertise) unregister() {
+	if m.updateTimer != nil {
+		m.updateTimer.Stop()
+		m.updateTimer = nil
+	}
+	if m.stopCh != nil {
+		close(m.stopCh)
+		m.stopCh = nil
+	}
+	shutdownRegistrations(m.registrations)
+	m.registrations = nil
+}
+
+// takeOver asks the other running advertisements to hand their registrations
+// over, see handOver, and returns those of the first that does.
+func (m *MdnsAdvertise) takeOver(instanceName, state string) ([]registration, *hostResponder) {
+	for _, prev := range advertisers.values() {
+		if prev == m {
+			continue
+		}
+		if registrations, hosts := prev.handOver(instanceName, m.services, m.ttl, state, m.hostRecords); registrations != nil {
+			log.Infof("Taking over the advertisement of %s", instanceName)
+			return registrations, hosts
+		}
+	}
+	return nil, nil
+}
+
+// handOver stops the advertisement for a successor which announces the same
+// services under the same instance name and TTL on the same interfaces, e.g.
+// the advertisement of a changed configuration on a reload. The
+// registrations, and the host responder when the host records match too, are
+// returned for the successor to keep announcing instead of being shut down,
+// so peers see no goodbye for the names. It returns nil when nothing can be
+// handed over.
+func (m *MdnsAdvertise) handOver(instanceName string, services []advertisedService, ttl uint32, state string, hostRecords map[string][]net.IP) ([]registration, *hostResponder) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.registrations == nil || m.instanceName != instanceName ||
+		m.ttl != ttl || m.ifaceState != state || !slices.Equal(m.services, services) {
+		return nil, nil
+	}
+
+	registrations := m.registrations
+	m.registrations = nil
+	hosts := (*hostResponder)(nil)
+	if m.hosts != nil && m.hostsState == state && reflect.DeepEqual(m.hostRecords, hostRecords) {
+		hosts = m.hosts
+		m.hosts = nil
+	}
+	// Stop the health check and the interface watch, which would otherwise
+	// register again.
+	m.stop()
+	return registrations, hosts
+}
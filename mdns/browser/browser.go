@@ -10,6 +10,8 @@ package browser
 
 import (
 	"context"
+	"net/netip"
+	"time"
 
 	"github.com/grandcat/zeroconf"
 )
@@ -18,5 +20,8 @@ type MdnsBrowserInterface interface {
 	Start() error
 	Stop()
 	Services() []*zeroconf.ServiceEntry
+	PartitionServices(partition string) []*zeroconf.ServiceEntry
 	ForceRefresh(ctx context.Context)
+	Zone(instance string, addr netip.Addr) string
+	Expiry(instance string) time.Time
 }
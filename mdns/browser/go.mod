@@ -4,17 +4,17 @@ go 1.25.1
 
 require (
 	github.com/coredns/coredns v1.12.4
+	github.com/grandcat/zeroconf v1.0.0
+	github.com/miekg/dns v1.1.68
 	github.com/stretchr/testify v1.10.0
+	golang.org/x/net v0.44.0
 )
 
 require (
 	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
-	github.com/grandcat/zeroconf v1.0.0 // indirect
-	github.com/miekg/dns v1.1.68 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	golang.org/x/mod v0.26.0 // indirect
-	golang.org/x/net v0.44.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
 	golang.org/x/sys v0.36.0 // indirect
 	golang.org/x/tools v0.35.0 // indirect
@@ -0,0 +1,106 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+)
+
+// mdnsGroupIPv4 is the IPv4 mDNS multicast address.
+var mdnsGroupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// LookupHost implements HostResolverInterface. It sends A and AAAA queries
+// for host on the interfaces, asking for unicast responses as in RFC 6762
+// section 5.4, and sends an entry for every response until ctx is done. Only
+// IPv4 is queried, responders include their IPv6 addresses all the same.
+func (z ZeroconfImpl) LookupHost(ctx context.Context, host string, ifaces []net.Interface, entries chan<- *zeroconf.ServiceEntry) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	pc := ipv4.NewPacketConn(conn)
+
+	q := new(dns.Msg)
+	q.Question = []dns.Question{
+		{Name: host, Qtype: dns.TypeA, Qclass: dns.ClassINET | 1<<15}, // unicast response requested
+		{Name: host, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET | 1<<15},
+	}
+	query, err := q.Pack()
+	if err != nil {
+		return err
+	}
+
+	sent := false
+	for _, iface := range ifaces {
+		if err := pc.SetMulticastInterface(&iface); err != nil {
+			continue
+		}
+		if _, err := pc.WriteTo(query, nil, mdnsGroupIPv4); err == nil {
+			sent = true
+		}
+	}
+	if !sent {
+		return errors.New("no interface could send the host query")
+	}
+
+	// Closing the connection ends the read below once ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		resp := new(dns.Msg)
+		if resp.Unpack(buf[:n]) != nil || !resp.Response {
+			continue
+		}
+		entry := hostEntry(resp, host)
+		if entry == nil {
+			continue
+		}
+		select {
+		case entries <- entry:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// hostEntry returns the addresses of host in a response as an entry, with the
+// lowest TTL of the records, or nil when the response has none.
+func hostEntry(resp *dns.Msg, host string) *zeroconf.ServiceEntry {
+	entry := &zeroconf.ServiceEntry{HostName: host}
+	for _, rr := range append(resp.Answer, resp.Extra...) {
+		hdr := rr.Header()
+		if hdr.Ttl == 0 || !strings.EqualFold(hdr.Name, host) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			entry.AddrIPv4 = append(entry.AddrIPv4, rr.A)
+		case *dns.AAAA:
+			entry.AddrIPv6 = append(entry.AddrIPv6, rr.AAAA)
+		default:
+			continue
+		}
+		if entry.TTL == 0 || hdr.Ttl < entry.TTL {
+			entry.TTL = hdr.Ttl
+		}
+	}
+	if len(entry.AddrIPv4) == 0 && len(entry.AddrIPv6) == 0 {
+		return nil
+	}
+	return entry
+}
@@ -0,0 +1,49 @@
+package browser
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHostEntry(t *testing.T) {
+	rrs := func(records ...string) []dns.RR {
+		parsed := []dns.RR{}
+		for _, record := range records {
+			rr, err := dns.NewRR(record)
+			if err != nil {
+				t.Fatalf("Failed to parse %q: %v", record, err)
+			}
+			parsed = append(parsed, rr)
+		}
+		return parsed
+	}
+
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Answer = rrs(
+		"NAS.local. 120 IN A 192.168.1.10",
+		"other.local. 120 IN A 192.168.1.11",
+		"nas.local. 0 IN A 192.168.1.12", // goodbye
+	)
+	resp.Extra = rrs("nas.local. 60 IN AAAA fd00::10")
+
+	entry := hostEntry(resp, "nas.local.")
+	if entry == nil {
+		t.Fatal("Expected an entry, but got none")
+	}
+	if len(entry.AddrIPv4) != 1 || !entry.AddrIPv4[0].Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("Unexpected IPv4 addresses: %v", entry.AddrIPv4)
+	}
+	if len(entry.AddrIPv6) != 1 || !entry.AddrIPv6[0].Equal(net.ParseIP("fd00::10")) {
+		t.Errorf("Unexpected IPv6 addresses: %v", entry.AddrIPv6)
+	}
+	if entry.TTL != 60 {
+		t.Errorf("Unexpected TTL: got %d, want 60", entry.TTL)
+	}
+
+	if entry := hostEntry(resp, "printer.local."); entry != nil {
+		t.Errorf("Expected no entry for another host, got %v", entry)
+	}
+}
@@ -84,8 +84,12 @@ func (r *ServiceRefresher) Refresh(ctx context.Context, entry *zeroconf.ServiceE
 	})
 }
 
-// StopAll cancels all active refresh timers.
+// StopAll cancels all active refresh timers. It is a no-op on a nil refresher,
+// as a browser that was never started has none.
 func (r *ServiceRefresher) StopAll() {
+	if r == nil {
+		return
+	}
 	r.timersMutex.Lock()
 	defer r.timersMutex.Unlock()
 	for _, timer := range r.timers {
@@ -9,6 +9,7 @@ import (
 
 type trackedService struct {
 	entry       *zeroconf.ServiceEntry
+	partition   string
 	originalTTL time.Duration
 	expiry      time.Time
 }
@@ -34,11 +35,17 @@ func (sc *serviceCache) removeEntry(instance string) {
 
 // addEntry receives an entry and adds it to the service map or removes it if TTL is 0.
 func (sc *serviceCache) addEntry(entry *zeroconf.ServiceEntry) {
+	sc.addPartitionEntry("", entry)
+}
+
+// addPartitionEntry adds an entry which belongs to the given partition.
+func (sc *serviceCache) addPartitionEntry(partition string, entry *zeroconf.ServiceEntry) {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
 
 	tracked := &trackedService{
 		entry:       entry,
+		partition:   partition,
 		originalTTL: time.Duration(entry.TTL) * time.Second,
 		expiry:      time.Now().Add(time.Duration(entry.TTL) * time.Second),
 	}
@@ -47,13 +54,21 @@ func (sc *serviceCache) addEntry(entry *zeroconf.ServiceEntry) {
 }
 
 func (sc *serviceCache) getServices() []*zeroconf.ServiceEntry {
+	return sc.filterServices(func(*trackedService) bool { return true })
+}
+
+func (sc *serviceCache) getPartitionServices(partition string) []*zeroconf.ServiceEntry {
+	return sc.filterServices(func(s *trackedService) bool { return s.partition == partition })
+}
+
+func (sc *serviceCache) filterServices(include func(*trackedService) bool) []*zeroconf.ServiceEntry {
 	now := time.Now()
 	sc.mutex.RLock()
 	defer sc.mutex.RUnlock()
 
 	serviceEntries := make([]*zeroconf.ServiceEntry, 0, len(*sc.services))
 	for _, s := range *sc.services {
-		if now.After(s.expiry) {
+		if now.After(s.expiry) || !include(s) {
 			continue
 		}
 		serviceEntries = append(serviceEntries, s.entry)
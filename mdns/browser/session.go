@@ -2,6 +2,7 @@ package browser
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
 
@@ -13,6 +14,10 @@ import (
 type ZeroconfSession struct {
 	zeroConfImpl ZeroconfInterface
 	interfaces   *[]net.Interface
+
+	// When set, queries run with one resolver per interface so that the
+	// interface each link-local address was learned on can be recorded.
+	zones *addrZones
 }
 
 func NewZeroconfSession(zeroConfImpl ZeroconfInterface, interfaces *[]net.Interface) *ZeroconfSession {
@@ -22,39 +27,69 @@ func NewZeroconfSession(zeroConfImpl ZeroconfInterface, interfaces *[]net.Interf
 	}
 }
 
+// resolverQuery runs a single Browse or Lookup against a resolver.
+type resolverQuery func(resolver ResolverInterface, entriesCh chan<- *zeroconf.ServiceEntry) error
+
 func (zs *ZeroconfSession) Browse(ctx context.Context, service string, domain string, entriesCh chan<- *zeroconf.ServiceEntry) error {
-	resolver, err := zs.zeroConfImpl.NewResolver(zs.getClientOption())
+	return zs.run(ctx, entriesCh, func(resolver ResolverInterface, ch chan<- *zeroconf.ServiceEntry) error {
+		return resolver.Browse(ctx, service, domain, ch)
+	})
+}
+
+func (zs *ZeroconfSession) Lookup(ctx context.Context, instance string, service string, domain string, entriesCh chan<- *zeroconf.ServiceEntry) error {
+	return zs.run(ctx, entriesCh, func(resolver ResolverInterface, ch chan<- *zeroconf.ServiceEntry) error {
+		return resolver.Lookup(ctx, instance, service, domain, ch)
+	})
+}
+
+// LookupHost looks up the addresses of a host, e.g. "nas.local.", on the
+// session's interfaces until ctx is done. Every response is sent as an entry
+// with HostName and the addresses set.
+func (zs *ZeroconfSession) LookupHost(ctx context.Context, host string, entriesCh chan<- *zeroconf.ServiceEntry) error {
+	resolver, ok := zs.zeroConfImpl.(HostResolverInterface)
+	if !ok {
+		return errors.New("host lookups are not supported")
+	}
+	ifaces, err := zs.zoneInterfaces()
 	if err != nil {
-		// No goroutine was started, so we can return directly.
 		return err
 	}
+	return resolver.LookupHost(ctx, host, ifaces, entriesCh)
+}
 
-	var wg sync.WaitGroup
-	localEntriesCh := make(chan *zeroconf.ServiceEntry)
-
-	// We want to wait for the go routine to finish before stopping the call to Run.
-	// Fanning in is a requirement becasue the localEntriesCh is ephemeral and managed by the session.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for entry := range localEntriesCh {
-			localEntry := *entry // make copy of entry so that zeroconf does not edit it from under us
-			entriesCh <- &localEntry
-		}
-	}()
+func (zs *ZeroconfSession) run(ctx context.Context, entriesCh chan<- *zeroconf.ServiceEntry, query resolverQuery) error {
+	if zs.zones == nil {
+		return zs.runOn(ctx, zs.getClientOption(), "", entriesCh, query)
+	}
 
-	// ASSUMPTION: resolver.Browse will close localEntriesCh when ctx is cancelled or times out.
-	err = resolver.Browse(ctx, service, domain, localEntriesCh)
-	if err != nil && ctx.Err() == nil { // Don't log error if it's just a context cancellation
+	ifaces, err := zs.zoneInterfaces()
+	if err != nil {
 		return err
 	}
 
+	var wg sync.WaitGroup
+	errs := make([]error, len(ifaces))
+	for idx, iface := range ifaces {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := zeroconf.SelectIfaces([]net.Interface{iface})
+			errs[idx] = zs.runOn(ctx, opts, iface.Name, entriesCh, query)
+		}()
+	}
 	wg.Wait()
-	return err
+
+	// Only report a failure when no interface could be queried.
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (zs *ZeroconfSession) Lookup(ctx context.Context, instance string, service string, domain string, entriesCh chan<- *zeroconf.ServiceEntry) error {
-	resolver, err := zs.zeroConfImpl.NewResolver(zs.getClientOption())
+func (zs *ZeroconfSession) runOn(ctx context.Context, opts zeroconf.ClientOption, iface string, entriesCh chan<- *zeroconf.ServiceEntry, query resolverQuery) error {
+	resolver, err := zs.zeroConfImpl.NewResolver(opts)
 	if err != nil {
 		// No goroutine was started, so we can return directly.
 		return err
@@ -70,12 +105,15 @@ func (zs *ZeroconfSession) Lookup(ctx context.Context, instance string, service
 		defer wg.Done()
 		for entry := range localEntriesCh {
 			localEntry := *entry // make copy of entry so that zeroconf does not edit it from under us
+			if zs.zones != nil {
+				zs.zones.record(&localEntry, iface)
+			}
 			entriesCh <- &localEntry
 		}
 	}()
 
-	// ASSUMPTION: resolver.Browse will close localEntriesCh when ctx is cancelled or times out.
-	err = resolver.Lookup(ctx, instance, service, domain, localEntriesCh)
+	// ASSUMPTION: resolver.Browse and resolver.Lookup will close localEntriesCh when ctx is cancelled or times out.
+	err = query(resolver, localEntriesCh)
 	if err != nil && ctx.Err() == nil { // Don't log error if it's just a context cancellation
 		return err
 	}
@@ -91,3 +129,24 @@ func (zs *ZeroconfSession) getClientOption() zeroconf.ClientOption {
 	}
 	return opts
 }
+
+// zoneInterfaces returns the interfaces to query individually: the configured
+// interfaces, or every multicast capable interface that is up.
+func (zs *ZeroconfSession) zoneInterfaces() ([]net.Interface, error) {
+	if zs.interfaces != nil {
+		return *zs.interfaces, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	found := []net.Interface{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 {
+			found = append(found, iface)
+		}
+	}
+	return found, nil
+}
@@ -3,6 +3,8 @@ package browser
 import (
 	"context"
 	"errors"
+	"net"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -156,3 +158,87 @@ func (m mockZeroconf) NewResolver(opts ...zeroconf.ClientOption) (ResolverInterf
 	}
 	return m.resolver, nil
 }
+
+func TestSessionRecordsInterfaceZones(t *testing.T) {
+	linkLocal := netip.MustParseAddr("fe80::1")
+	entry := newEntry("host0", 120)
+	entry.AddrIPv6 = []net.IP{linkLocal.AsSlice(), net.ParseIP("2001:db8::1")}
+
+	ifaces := []net.Interface{{Name: "mock0"}}
+	session := NewZeroconfSession(entryZeroconf{entry: entry}, &ifaces)
+	session.zones = newAddrZones()
+
+	entriesCh := make(chan *zeroconf.ServiceEntry, 1)
+	if err := session.Browse(context.Background(), "_test._tcp", "local.", entriesCh); err != nil {
+		t.Fatalf("session.Browse returned an unexpected error: %v", err)
+	}
+	<-entriesCh
+
+	if zone := session.zones.zone("host0", linkLocal); zone != "mock0" {
+		t.Errorf("Unexpected zone for %s: got %q, want %q", linkLocal, zone, "mock0")
+	}
+	if zone := session.zones.zone("host0", netip.MustParseAddr("2001:db8::1")); zone != "" {
+		t.Errorf("Unexpected zone for a global address: got %q, want none", zone)
+	}
+}
+
+// entryZeroconf returns resolvers which emit a single entry and return.
+type entryZeroconf struct {
+	entry *zeroconf.ServiceEntry
+}
+
+func (m entryZeroconf) NewResolver(opts ...zeroconf.ClientOption) (ResolverInterface, error) {
+	return entryResolver{entry: m.entry}, nil
+}
+
+type entryResolver struct {
+	entry *zeroconf.ServiceEntry
+}
+
+func (r entryResolver) Browse(ctx context.Context, service, domain string, entries chan<- *zeroconf.ServiceEntry) error {
+	defer close(entries)
+	entries <- r.entry
+	return nil
+}
+
+func (r entryResolver) Lookup(ctx context.Context, instance, service, domain string, entries chan<- *zeroconf.ServiceEntry) error {
+	defer close(entries)
+	entries <- r.entry
+	return nil
+}
+
+func TestSessionLookupHost(t *testing.T) {
+	ifaces := []net.Interface{{Name: "mock0"}}
+	resolver := &hostZeroconf{entry: &zeroconf.ServiceEntry{HostName: "nas.local.", AddrIPv4: []net.IP{net.ParseIP("192.168.1.10")}}}
+	session := NewZeroconfSession(resolver, &ifaces)
+
+	entriesCh := make(chan *zeroconf.ServiceEntry, 1)
+	if err := session.LookupHost(context.Background(), "nas.local.", entriesCh); err != nil {
+		t.Fatalf("session.LookupHost returned an unexpected error: %v", err)
+	}
+	if entry := <-entriesCh; entry != resolver.entry {
+		t.Errorf("Unexpected entry: got %v, want %v", entry, resolver.entry)
+	}
+	if resolver.host != "nas.local." || len(resolver.ifaces) != 1 || resolver.ifaces[0].Name != "mock0" {
+		t.Errorf("Unexpected lookup of %q on %v", resolver.host, resolver.ifaces)
+	}
+
+	unsupported := NewZeroconfSession(mockZeroconf{resolver: &mockResolver{}}, &ifaces)
+	if err := unsupported.LookupHost(context.Background(), "nas.local.", entriesCh); err == nil {
+		t.Error("Expected an error without host lookup support, but got none")
+	}
+}
+
+// hostZeroconf looks up hosts with a single entry and records the query.
+type hostZeroconf struct {
+	mockZeroconf
+	entry  *zeroconf.ServiceEntry
+	host   string
+	ifaces []net.Interface
+}
+
+func (m *hostZeroconf) LookupHost(ctx context.Context, host string, ifaces []net.Interface, entries chan<- *zeroconf.ServiceEntry) error {
+	m.host, m.ifaces = host, ifaces
+	entries <- m.entry
+	return nil
+}
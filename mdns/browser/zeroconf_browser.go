@@ -12,7 +12,10 @@ package browser
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"net/netip"
+	"strings"
 	"sync"
 	"time"
 
@@ -50,8 +53,14 @@ type ZeroconfBrowser struct {
 	wg        sync.WaitGroup
 
 	cancelBrowse context.CancelFunc // Cancels the main browse loop and all derived contexts
+
+	refreshMutex sync.Mutex
+	browseCtx    context.Context // set while entriesCh accepts entries
+	refreshes    sync.WaitGroup  // force refreshes sending to entriesCh
 	cache        *serviceCache
 	refresher    *ServiceRefresher
+	zones        *addrZones // nil unless interface zones are tracked
+	partitionKey string     // TXT key used to partition the cache, "" for no partitioning
 }
 
 func NewZeroconfBrowser(domain, mdnsType string, interfaces *[]net.Interface) (browser *ZeroconfBrowser) {
@@ -67,11 +76,39 @@ func NewZeroconfBrowser(domain, mdnsType string, interfaces *[]net.Interface) (b
 	return browser
 }
 
+// TrackInterfaceZones makes the browser query each interface separately and
+// record which interface every IPv6 link-local address was learned on. It
+// must be called before Start.
+func (m *ZeroconfBrowser) TrackInterfaceZones() {
+	m.zones = newAddrZones()
+}
+
+// PartitionBy partitions the service cache by the value of a TXT key, so
+// that services can be listed per partition with PartitionServices. Services
+// without the key belong to the "" partition. It must be called before Start.
+func (m *ZeroconfBrowser) PartitionBy(txtKey string) {
+	m.partitionKey = txtKey
+}
+
 func (m *ZeroconfBrowser) Start() error {
 	m.Log.Infof("Starting mDNS browser...")
 	m.startOnce.Do(func() {
+		// Everything Stop relies on is set up before returning, so that an
+		// immediate Stop cancels the browse loop.
+		ctx, cancel := context.WithCancel(context.Background())
+		m.cancelBrowse = cancel
+
+		m.entriesCh = make(chan *zeroconf.ServiceEntry, 10)
+		m.refreshMutex.Lock()
+		m.browseCtx = ctx
+		m.refreshMutex.Unlock()
+		session := m.newSession()
+
+		m.refresher = newServiceRefresher(m.service, m.domain, session, m.cache, m.entriesCh, m.removeService)
+		m.refresher.Log = m.Log
+
 		m.wg.Add(1)
-		go m.browseLoop() // browseLoop will call wg.Done() when it exits
+		go m.browseLoop(ctx, session) // browseLoop will call wg.Done() when it exits
 	})
 	return nil
 }
@@ -97,6 +134,11 @@ func (m *ZeroconfBrowser) Services() []*zeroconf.ServiceEntry {
 	return m.cache.getServices()
 }
 
+// PartitionServices returns the services in a single partition of the cache.
+func (m *ZeroconfBrowser) PartitionServices(partition string) []*zeroconf.ServiceEntry {
+	return m.cache.getPartitionServices(partition)
+}
+
 func (m *ZeroconfBrowser) Service() string {
 	return m.service
 }
@@ -109,26 +151,62 @@ func (m *ZeroconfBrowser) Interfaces() *[]net.Interface {
 	return m.interfaces
 }
 
+// Key identifies the configuration of the browser. Browsers with the same key
+// browse for the same services in the same way.
+func (m *ZeroconfBrowser) Key() string {
+	ifaces := "*"
+	if m.interfaces != nil {
+		names := []string{}
+		for _, iface := range *m.interfaces {
+			names = append(names, iface.Name)
+		}
+		ifaces = strings.Join(names, ",")
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%t", m.domain, m.service, ifaces, m.partitionKey, m.zones != nil)
+}
+
+// Expiry returns when the cached record of an instance expires, or the zero
+// time if the instance is not known.
+func (m *ZeroconfBrowser) Expiry(instance string) time.Time {
+	return m.cache.getExpiry(instance)
+}
+
+// Zone returns the name of the interface on which the given link-local
+// address of an instance was learned, or "" if it is not known.
+func (m *ZeroconfBrowser) Zone(instance string, addr netip.Addr) string {
+	if m.zones == nil {
+		return ""
+	}
+	return m.zones.zone(instance, addr)
+}
+
 // ForceRefresh triggers a non-blocking, one-shot mDNS browse operation to quickly
 // rediscover services on the network. This is useful to call reactively when an
 // operation like a DNS query fails, as it can refresh the cache with up-to-date
-// service information without waiting for the next TTL-based refresh.
+// service information without waiting for the next TTL-based refresh. It does
+// nothing unless the browser is running, and is cut short by Stop.
 func (m *ZeroconfBrowser) ForceRefresh(ctx context.Context) {
+	m.refreshMutex.Lock()
+	browseCtx := m.browseCtx
+	if browseCtx == nil {
+		m.refreshMutex.Unlock()
+		m.Log.Debugf("Browser for '%s' is not running, skipping force-refresh.", m.service)
+		return
+	}
+	m.refreshes.Add(1)
+	m.refreshMutex.Unlock()
+	defer m.refreshes.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(browseCtx, cancel)()
+
 	m.Log.Infof("Force-refresh triggered. Performing a one-shot browse for '%s'.", m.service)
-	session := NewZeroconfSession(m.zeroConfImpl, m.interfaces)
+	session := m.newSession()
 	_ = session.Browse(ctx, m.service, m.domain, m.entriesCh)
 }
 
-func (m *ZeroconfBrowser) browseLoop() {
-	outerCtx, outerCancel := context.WithCancel(context.Background())
-	m.cancelBrowse = outerCancel
-
-	m.entriesCh = make(chan *zeroconf.ServiceEntry, 10)
-	session := NewZeroconfSession(m.zeroConfImpl, m.interfaces)
-
-	m.refresher = newServiceRefresher(m.service, m.domain, session, m.cache, m.entriesCh, m.removeService)
-	m.refresher.Log = m.Log
-
+func (m *ZeroconfBrowser) browseLoop(outerCtx context.Context, session *ZeroconfSession) {
 	// This goroutine handles processing entries and shutting down.
 	go func() {
 		defer m.wg.Done()
@@ -137,9 +215,22 @@ func (m *ZeroconfBrowser) browseLoop() {
 
 	// This call blocks until the context is canceled.
 	_ = session.Browse(outerCtx, m.service, m.domain, m.entriesCh)
+
+	// Refuse new force refreshes and wait for the running ones, which are
+	// canceled with outerCtx, before closing the channel they send to.
+	m.refreshMutex.Lock()
+	m.browseCtx = nil
+	m.refreshMutex.Unlock()
+	m.refreshes.Wait()
 	close(m.entriesCh)
 }
 
+func (m *ZeroconfBrowser) newSession() *ZeroconfSession {
+	session := NewZeroconfSession(m.zeroConfImpl, m.interfaces)
+	session.zones = m.zones
+	return session
+}
+
 func (m *ZeroconfBrowser) processEntries(ctx context.Context, entriesCh chan *zeroconf.ServiceEntry) {
 	for entry := range entriesCh {
 		if entry == nil {
@@ -156,14 +247,31 @@ func (m *ZeroconfBrowser) processEntries(ctx context.Context, entriesCh chan *ze
 			} else {
 				m.Log.Debugf("Service updated:\n    Instance: %s\n    Service: %s\n    HostName: %s\n    AddrIPv4: %s\n    AddrIPv6: %s\n    Port: %d\n    TTL: %d", entry.Instance, entry.Service, entry.HostName, entry.AddrIPv4, entry.AddrIPv6, entry.Port, entry.TTL)
 			}
-			m.cache.addEntry(entry)
+			m.cache.addPartitionEntry(m.partition(entry), entry)
 			m.refresher.Refresh(ctx, entry)
 		}
 	}
 }
 
+// partition returns the value of the partition key in the entry's TXT record.
+func (m *ZeroconfBrowser) partition(entry *zeroconf.ServiceEntry) string {
+	if m.partitionKey == "" {
+		return ""
+	}
+	for _, txt := range entry.Text {
+		key, value, _ := strings.Cut(txt, "=")
+		if strings.EqualFold(key, m.partitionKey) {
+			return value
+		}
+	}
+	return ""
+}
+
 func (m *ZeroconfBrowser) removeService(entry *zeroconf.ServiceEntry) {
 	m.cache.removeEntry(entry.Instance)
+	if m.zones != nil {
+		m.zones.remove(entry.Instance)
+	}
 	// No need to call refresher.Stop() here as the timer will fire and do nothing,
 	// and the next time a service with this name appears, Refresh() will overwrite the timer.
 }
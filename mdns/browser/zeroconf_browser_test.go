@@ -150,6 +150,105 @@ func TestZeroconfBrowserDoesAddService(t *testing.T) {
 	}
 }
 
+func TestZeroconfBrowserPartitions(t *testing.T) {
+	logger := NewTestLogger(t)
+	fakeZeroconf := &controllableFakeZeroconf{
+		logger:          logger,
+		browseEntriesCh: make(chan *zeroconf.ServiceEntry),
+		lookupEntriesCh: make(chan *zeroconf.ServiceEntry),
+		lookupCalls:     make(map[string]int),
+	}
+
+	browser := NewZeroconfBrowser(".local", "_type", nil)
+	browser.Log = logger
+	browser.zeroConfImpl = fakeZeroconf
+	browser.PartitionBy("mesh")
+	browser.Start()
+
+	home := newEntry("host0", 120)
+	home.Text = []string{"mesh=home"}
+	lab := newEntry("host1", 120)
+	lab.Text = []string{"other=1", "mesh=lab"}
+	none := newEntry("host2", 120)
+
+	for _, entry := range []*zeroconf.ServiceEntry{home, lab, none} {
+		fakeZeroconf.browseEntriesCh <- entry
+	}
+
+	browser.Stop()
+
+	testCases := []struct {
+		partition string
+		expected  []string
+	}{
+		{partition: "home", expected: []string{"host0"}},
+		{partition: "lab", expected: []string{"host1"}},
+		{partition: "", expected: []string{"host2"}},
+		{partition: "unknown", expected: []string{}},
+	}
+	for _, tc := range testCases {
+		services := browser.PartitionServices(tc.partition)
+		if len(services) != len(tc.expected) {
+			t.Fatalf("Unexpected service count for partition %q: got %d, want %d", tc.partition, len(services), len(tc.expected))
+		}
+		for idx, service := range services {
+			if service.Instance != tc.expected[idx] {
+				t.Errorf("Unexpected service in partition %q: got %s, want %s", tc.partition, service.Instance, tc.expected[idx])
+			}
+		}
+	}
+
+	if len(browser.Services()) != 3 {
+		t.Errorf("Unexpected total service count: got %d, want 3", len(browser.Services()))
+	}
+}
+
+func TestZeroconfBrowserForceRefreshStopped(t *testing.T) {
+	logger := NewTestLogger(t)
+	fakeZeroconf := &controllableFakeZeroconf{
+		logger:          logger,
+		browseEntriesCh: make(chan *zeroconf.ServiceEntry),
+		lookupEntriesCh: make(chan *zeroconf.ServiceEntry),
+		lookupCalls:     make(map[string]int),
+	}
+	browseCalls := func() int {
+		fakeZeroconf.mutex.Lock()
+		defer fakeZeroconf.mutex.Unlock()
+		return fakeZeroconf.browseCalls
+	}
+
+	browser := NewZeroconfBrowser(".local", "_type", nil)
+	browser.Log = logger
+	browser.zeroConfImpl = fakeZeroconf
+
+	browser.ForceRefresh(context.Background())
+	if calls := browseCalls(); calls != 0 {
+		t.Fatalf("Expected no browse before Start, got %d", calls)
+	}
+
+	browser.Start()
+	// A running refresh without a deadline is cut short by Stop.
+	refreshed := make(chan struct{})
+	go func() {
+		browser.ForceRefresh(context.Background())
+		close(refreshed)
+	}()
+	for browseCalls() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	browser.Stop()
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Stop to end the running refresh")
+	}
+
+	browser.ForceRefresh(context.Background())
+	if calls := browseCalls(); calls != 2 {
+		t.Errorf("Expected no browse after Stop, got %d calls", calls)
+	}
+}
+
 func TestTTL(t *testing.T) {
 	clog.D.Set()
 	testCases := []struct {
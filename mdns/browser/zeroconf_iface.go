@@ -3,6 +3,7 @@ package browser
 
 import (
 	"context"
+	"net"
 
 	"github.com/grandcat/zeroconf"
 )
@@ -24,3 +25,8 @@ type ResolverInterface interface {
 	Lookup(ctx context.Context, instance string, service string, domain string, entries chan<- *zeroconf.ServiceEntry) error
 }
 
+// HostResolverInterface is implemented by a ZeroconfInterface which can look
+// up the addresses of host names, which the zeroconf resolver does not do.
+type HostResolverInterface interface {
+	LookupHost(ctx context.Context, host string, ifaces []net.Interface, entries chan<- *zeroconf.ServiceEntry) error
+}
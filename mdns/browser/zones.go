@@ -0,0 +1,58 @@
+package browser
+
+import (
+	"net/netip"
+	"sync"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// addrZones tracks the interface on which each IPv6 link-local address of a
+// service instance was learned. Link-local addresses are only reachable when
+// dialed with a zone (e.g. fe80::1%eth0), and the zone cannot be derived from
+// the address itself.
+type addrZones struct {
+	mutex *sync.RWMutex
+	zones map[string]map[netip.Addr]string // instance -> address -> interface name
+}
+
+func newAddrZones() *addrZones {
+	return &addrZones{
+		mutex: &sync.RWMutex{},
+		zones: make(map[string]map[netip.Addr]string),
+	}
+}
+
+// record stores the interface for every link-local address of the entry.
+func (az *addrZones) record(entry *zeroconf.ServiceEntry, iface string) {
+	if iface == "" {
+		return
+	}
+
+	az.mutex.Lock()
+	defer az.mutex.Unlock()
+
+	for _, ip := range entry.AddrIPv6 {
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok || !addr.IsLinkLocalUnicast() {
+			continue
+		}
+		if az.zones[entry.Instance] == nil {
+			az.zones[entry.Instance] = make(map[netip.Addr]string)
+		}
+		az.zones[entry.Instance][addr.WithZone("")] = iface
+	}
+}
+
+// zone returns the interface name for an address of an instance, or "" if unknown.
+func (az *addrZones) zone(instance string, addr netip.Addr) string {
+	az.mutex.RLock()
+	defer az.mutex.RUnlock()
+	return az.zones[instance][addr.WithZone("")]
+}
+
+func (az *addrZones) remove(instance string) {
+	az.mutex.Lock()
+	defer az.mutex.Unlock()
+	delete(az.zones, instance)
+}
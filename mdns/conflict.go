@@ -0,0 +1,286 @@
+package mdns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+)
+
+// mdnsGroupIPv4 is the IPv4 mDNS multicast address.
+var mdnsGroupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// peerInstance is a service instance announced by a responder.
+type peerInstance struct {
+	instance string
+	port     int
+	from     net.IP // address of the responder
+}
+
+// serviceProber returns the instances of a service, e.g. "_dns._udp.local.",
+// announced on the interfaces.
+type serviceProber func(ctx context.Context, service string, ifaces []net.Interface) ([]peerInstance, error)
+
+// probeService sends ProbeCount queries for the instances of service,
+// ProbeInterval apart, similar to the probing of RFC 6762 section 8.1. The
+// queries ask for unicast responses, so that every responder answers this
+// socket directly. Only IPv4 is probed.
+func probeService(ctx context.Context, service string, ifaces []net.Interface) ([]peerInstance, error) {
+	if ifaces == nil {
+		all, err := multicastInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		ifaces = all
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	pc := ipv4.NewPacketConn(conn)
+
+	q := new(dns.Msg)
+	q.SetQuestion(service, dns.TypePTR)
+	q.Question[0].Qclass |= 1 << 15 // unicast response requested
+	q.RecursionDesired = false
+	query, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	peers := []peerInstance{}
+	buf := make([]byte, 65536)
+	for i := 0; i < ProbeCount; i++ {
+		sent := false
+		for _, iface := range ifaces {
+			if err := pc.SetMulticastInterface(&iface); err != nil {
+				continue
+			}
+			if _, err := pc.WriteTo(query, nil, mdnsGroupIPv4); err == nil {
+				sent = true
+			}
+		}
+		if !sent {
+			return nil, errors.New("no interface could send the probe")
+		}
+
+		deadline := time.Now().Add(ProbeInterval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break // read deadline reached
+			}
+			resp := new(dns.Msg)
+			if resp.Unpack(buf[:n]) != nil || !resp.Response {
+				continue
+			}
+			peers = append(peers, responseInstances(resp, service, from.IP)...)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return peers, nil
+}
+
+// responseInstances returns the instances of service with an SRV record in
+// a response.
+func responseInstances(resp *dns.Msg, service string, from net.IP) []peerInstance {
+	peers := []peerInstance{}
+	suffix := "." + strings.ToLower(service)
+	for _, rr := range append(resp.Answer, resp.Extra...) {
+		srv, ok := rr.(*dns.SRV)
+		if !ok || srv.Hdr.Ttl == 0 || !strings.HasSuffix(strings.ToLower(srv.Hdr.Name), suffix) {
+			continue
+		}
+		label := srv.Hdr.Name[:len(srv.Hdr.Name)-len(suffix)]
+		peers = append(peers, peerInstance{instance: unescapeLabel(label), port: int(srv.Port), from: from})
+	}
+	return peers
+}
+
+// foreign reports whether a peer instance is announced by another responder:
+// one on another host, or one on this host for another port.
+func (p peerInstance) foreign(port int, local []*net.IPNet) bool {
+	for _, n := range local {
+		if n.IP.Equal(p.from) {
+			return p.port != port
+		}
+	}
+	return true
+}
+
+// yields reports whether this node gives up an instance name it shares with
+// peer at runtime. The node with the lower address keeps the name, or the
+// one with the lower port when both run on this host, so that only one side
+// of a conflict renames.
+func (p peerInstance) yields(port int, local []*net.IPNet) bool {
+	own := localAddr(p.from, local)
+	if own == nil {
+		return true
+	}
+	if own.Equal(p.from) {
+		return port > p.port
+	}
+	return bytes.Compare(own.To16(), p.from.To16()) > 0
+}
+
+// localAddr returns the address of this host which shares a subnet with ip,
+// ip itself when it belongs to this host, or nil.
+func localAddr(ip net.IP, local []*net.IPNet) net.IP {
+	var found net.IP
+	for _, n := range local {
+		if n.IP.Equal(ip) {
+			return n.IP
+		}
+		if found == nil && n.Contains(ip) {
+			found = n.IP
+		}
+	}
+	return found
+}
+
+// localNets returns the addresses of this host with their subnets.
+func localNets() []*net.IPNet {
+	nets := []*net.IPNet{}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+// instanceNameCandidate returns the n-th candidate for an instance name: the
+// name itself, then "name (2)", "name (3)" and so on.
+func instanceNameCandidate(base string, n int) string {
+	if n <= 1 {
+		return base
+	}
+	return fmt.Sprintf("%s (%d)", base, n)
+}
+
+// probePeers probes every advertised service and returns the foreign
+// instances found for each.
+func (m *MdnsAdvertise) probePeers(ifaces []net.Interface) map[advertisedService][]peerInstance {
+	local := localNets()
+	peers := make(map[advertisedService][]peerInstance)
+	for _, s := range m.services {
+		ctx, cancel := context.WithTimeout(context.Background(), ProbeCount*ProbeInterval+time.Second)
+		found, err := m.probe(ctx, fmt.Sprintf("%s.%s.", strings.Trim(s.service, "."), strings.Trim(m.domain, ".")), ifaces)
+		cancel()
+		if err != nil {
+			log.Warningf("Failed to probe for instance name conflicts of %s: %s", s.service, err)
+		}
+		for _, p := range found {
+			if p.foreign(s.port, local) {
+				peers[s] = append(peers[s], p)
+			}
+		}
+	}
+	return peers
+}
+
+// probeUnlocked probes the interfaces for the instance names of other
+// responders. Probing takes about a second per service type, so the mutex,
+// which the caller must hold, is released meanwhile and the caller has to
+// check its state again afterwards.
+func (m *MdnsAdvertise) probeUnlocked(ifaces []net.Interface) map[advertisedService][]peerInstance {
+	m.mutex.Unlock()
+	defer m.mutex.Lock()
+	return m.probePeers(ifaces)
+}
+
+// freeInstanceName returns the first candidate instance name which no other
+// responder uses for any of the advertised services.
+func freeInstanceName(base string, peers map[advertisedService][]peerInstance) string {
+	taken := make(map[string]bool)
+	for _, found := range peers {
+		for _, p := range found {
+			taken[strings.ToLower(p.instance)] = true
+		}
+	}
+
+	candidate := base
+	for n := 1; taken[strings.ToLower(candidate)]; n++ {
+		candidate = instanceNameCandidate(base, n+1)
+	}
+	return candidate
+}
+
+// claimInstanceName picks the first free instance name, see
+// freeInstanceName. The caller must hold the mutex.
+func (m *MdnsAdvertise) claimInstanceName(peers map[advertisedService][]peerInstance) {
+	candidate := freeInstanceName(m.baseInstanceName, peers)
+	if candidate != m.instanceName && candidate != m.baseInstanceName {
+		log.Warningf("Instance name %q is already in use, advertising as %q", m.baseInstanceName, candidate)
+	}
+	m.instanceName = candidate
+}
+
+// watchConflicts probes for other responders using the instance name every
+// ConflictCheckInterval while registered, and renames the advertisement when
+// this node loses the conflict.
+func (m *MdnsAdvertise) watchConflicts(stopCh chan struct{}) {
+	ticker := time.NewTicker(ConflictCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			m.checkConflicts(stopCh)
+		}
+	}
+}
+
+func (m *MdnsAdvertise) checkConflicts(stopCh chan struct{}) {
+	m.mutex.Lock()
+	if m.watchStopCh != stopCh || m.registrations == nil {
+		m.mutex.Unlock()
+		return
+	}
+	instanceName := m.instanceName
+	ifaces, err := m.advertisedInterfaces()
+	m.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	// Probing takes a while, so it runs without holding the mutex.
+	peers := m.probePeers(ifaces)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.watchStopCh != stopCh || m.registrations == nil || m.instanceName != instanceName {
+		return
+	}
+
+	local := localNets()
+	for s, found := range peers {
+		for _, p := range found {
+			if !strings.EqualFold(p.instance, instanceName) || !p.yields(s.port, local) {
+				continue
+			}
+			log.Warningf("Instance name %q is also used by %s, renaming", instanceName, p.from)
+			m.unregister()
+			if err := m.register(ifaces, peers); err != nil {
+				log.Errorf("Error registering advertisement: %s", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResponseInstances(t *testing.T) {
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Answer = []dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: "_dns._udp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+		Ptr: "meshdns-node (2)._dns._udp.local.",
+	}}
+	resp.Extra = []dns.RR{
+		&dns.SRV{
+			Hdr:    dns.RR_Header{Name: "meshdns-node (2)._dns._udp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120},
+			Port:   53,
+			Target: "node.local.",
+		},
+		&dns.SRV{
+			Hdr:    dns.RR_Header{Name: "gone._dns._udp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 0},
+			Port:   53,
+			Target: "node.local.",
+		},
+		&dns.SRV{
+			Hdr:    dns.RR_Header{Name: "other._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120},
+			Port:   80,
+			Target: "node.local.",
+		},
+	}
+
+	// Names are escaped once the response went over the wire.
+	packed, err := resp.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpacked := new(dns.Msg)
+	if err := unpacked.Unpack(packed); err != nil {
+		t.Fatal(err)
+	}
+
+	from := net.ParseIP("192.168.2.7")
+	peers := responseInstances(unpacked, "_dns._udp.local.", from)
+	expected := []peerInstance{{instance: "meshdns-node (2)", port: 53, from: from}}
+	if !reflect.DeepEqual(peers, expected) {
+		t.Errorf("Expected %v, got %v", expected, peers)
+	}
+}
+
+func TestUnescapeLabel(t *testing.T) {
+	testCases := map[string]string{
+		`plain`:               "plain",
+		`meshdns-node\ \(2\)`: "meshdns-node (2)",
+		`dot\.ted`:            "dot.ted",
+		`byte\065`:            "byteA",
+		`trailing\`:           `trailing\`,
+	}
+	for label, expected := range testCases {
+		if got := unescapeLabel(label); got != expected {
+			t.Errorf("unescapeLabel(%q): expected %q, got %q", label, expected, got)
+		}
+	}
+}
+
+func TestPeerInstanceConflicts(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.2.0/24")
+	lan.IP = net.ParseIP("192.168.2.5")
+	local := []*net.IPNet{lan}
+
+	testCases := []struct {
+		name    string
+		peer    peerInstance
+		foreign bool
+		yields  bool
+	}{
+		{name: "this responder", peer: peerInstance{port: 53, from: net.ParseIP("192.168.2.5")}},
+		{name: "same host, lower port", peer: peerInstance{port: 52, from: net.ParseIP("192.168.2.5")}, foreign: true, yields: true},
+		{name: "same host, higher port", peer: peerInstance{port: 54, from: net.ParseIP("192.168.2.5")}, foreign: true},
+		{name: "lower address", peer: peerInstance{port: 53, from: net.ParseIP("192.168.2.4")}, foreign: true, yields: true},
+		{name: "higher address", peer: peerInstance{port: 53, from: net.ParseIP("192.168.2.6")}, foreign: true},
+		{name: "other subnet", peer: peerInstance{port: 53, from: net.ParseIP("10.0.0.1")}, foreign: true, yields: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if foreign := tc.peer.foreign(53, local); foreign != tc.foreign {
+				t.Errorf("Expected foreign=%v, got %v", tc.foreign, foreign)
+			}
+			if tc.foreign {
+				if yields := tc.peer.yields(53, local); yields != tc.yields {
+					t.Errorf("Expected yields=%v, got %v", tc.yields, yields)
+				}
+			}
+		})
+	}
+}
+
+func TestClaimInstanceName(t *testing.T) {
+	a := NewMdnsAdvertise("meshdns-node", "_dns._udp", 53, 60)
+	a.AddService("_dns._tcp", 53)
+	remote := net.ParseIP("203.0.113.1") // never local
+
+	a.probe = func(ctx context.Context, service string, ifaces []net.Interface) ([]peerInstance, error) {
+		switch service {
+		case "_dns._udp.local.":
+			return []peerInstance{{instance: "meshdns-node", port: 53, from: remote}}, nil
+		case "_dns._tcp.local.":
+			return []peerInstance{{instance: "MESHDNS-NODE (2)", port: 53, from: remote}}, nil
+		}
+		t.Errorf("Unexpected probe for %s", service)
+		return nil, nil
+	}
+
+	peers := a.probePeers(nil)
+	if len(peers) != 2 {
+		t.Fatalf("Expected peers for both services, got %v", peers)
+	}
+	a.claimInstanceName(peers)
+	if a.instanceName != "meshdns-node (3)" {
+		t.Errorf("Expected the first free name, got %q", a.instanceName)
+	}
+
+	// Once the name is free again the configured name is used.
+	a.claimInstanceName(nil)
+	if a.instanceName != "meshdns-node" {
+		t.Errorf("Expected the configured name, got %q", a.instanceName)
+	}
+}
+
+func TestProbeDoesNotHoldMutex(t *testing.T) {
+	a := NewMdnsAdvertise("meshdns-node", "_dns._udp", 53, 60)
+	probing := make(chan struct{})
+	release := make(chan struct{})
+	a.probe = func(ctx context.Context, service string, ifaces []net.Interface) ([]peerInstance, error) {
+		close(probing)
+		<-release
+		return nil, nil
+	}
+
+	// Recovering from a failed health check probes before registering.
+	stopCh := make(chan struct{})
+	a.healthStopCh = stopCh
+	a.withdrawn = true
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.applyHealth(true, stopCh)
+	}()
+	<-probing
+
+	updated := make(chan error)
+	go func() { updated <- a.SetTxt("role", "prod") }()
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Errorf("Expected no error, but got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected SetTxt not to wait for the probe")
+	}
+
+	// The health loop stops while probing, so nothing is registered.
+	a.mutex.Lock()
+	a.healthStopCh = nil
+	a.mutex.Unlock()
+	close(release)
+	<-done
+	if a.registrations != nil {
+		t.Error("Expected no registration once the health loop stopped")
+	}
+}
@@ -10,11 +10,41 @@ const (
 
 	ForwardPluginName    			   = "dnsmesh_mdns_forward"
 	AdvertisePluginName  			   = "dnsmesh_mdns_advertise"
+	ProxyPluginName      			   = "dnsmesh_mdns_proxy"
 
 	AdvertisingPrefix   			   = "meshdns-"
 	DefaultTTL   				uint32 = 320
 
-	DefaultTimeout      time.Duration = time.Second * 30
-	DefaultAddrsPerHost               = 1
-	DefaultAddrMode                   = IPv4Only
+	DefaultTimeout        time.Duration = time.Second * 5 // whole request, including the retry after a refresh
+	DefaultPeerTimeout    time.Duration = time.Second * 2 // a single peer, including a TCP retry
+	DefaultRefreshTimeout time.Duration = time.Second * 1 // forced mDNS refresh after a failed attempt
+	DefaultAddrsPerHost                 = 1
+	DefaultAddrMode                     = IPv4Only
+
+	DefaultSignatureMaxAge   time.Duration = time.Minute * 15
+	SignatureRefreshInterval time.Duration = time.Minute * 5
+	TxtUpdateDelay           time.Duration = time.Second // debounces runtime TXT changes
+	InterfacePollInterval    time.Duration = time.Second * 5
+	ConflictCheckInterval    time.Duration = time.Minute
+	ProbeInterval            time.Duration = time.Millisecond * 250
+	ProbeCount                             = 3
+
+	DefaultHealthInterval  time.Duration = time.Second * 10
+	DefaultHealthTimeout   time.Duration = time.Second * 2
+	DefaultHealthFailures                = 3
+	DefaultHealthSuccesses               = 2
+
+	DefaultProxyTTL        uint32        = 10 // capped as recommended by RFC 8766 section 5.5.1
+	ProxyHostLookupTimeout time.Duration = time.Second
+)
+
+// TXT keys published by the advertiser and consumed by the mesh itself.
+const (
+	TxtMesh      = "mesh"
+	TxtNodeID    = "node_id"
+	TxtTimestamp = "ts"
+	TxtSignature = "sig"
+	TxtSubnets   = "subnets"
+	TxtZones     = "zones"
+	TxtListen    = "listen"
 )
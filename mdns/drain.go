@@ -0,0 +1,40 @@
+package mdns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+var (
+	// drainOnce registers drainOnShutdown once per process.
+	drainOnce sync.Once
+	// drainSleep waits for the drain period.
+	drainSleep = time.Sleep
+)
+
+// drainOnShutdown sends the goodbye of every advertisement with a drain
+// period when CoreDNS is asked to exit, then waits for the longest of the
+// periods, so that peers drop the node before it stops answering. Caddy
+// emits the shutdown event before it runs any shutdown callback, so the
+// servers and browsers keep running while draining. Reloads emit no shutdown
+// event and are not delayed.
+func drainOnShutdown(event caddy.EventName, _ interface{}) error {
+	if event != caddy.ShutdownEvent {
+		return nil
+	}
+
+	drain := time.Duration(0)
+	for _, advertiser := range advertisers.values() {
+		if advertiser.drain > 0 && advertiser.advertising() {
+			advertiser.StopAdvertise()
+			drain = max(drain, advertiser.drain)
+		}
+	}
+	if drain > 0 {
+		log.Infof("Sent goodbye, draining for %s before shutting down", drain)
+		drainSleep(drain)
+	}
+	return nil
+}
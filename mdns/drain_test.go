@@ -0,0 +1,64 @@
+package mdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+// stopRecordingBrowser is a fakeBrowser which records when it is stopped.
+type stopRecordingBrowser struct {
+	fakeBrowser
+	onStop func()
+}
+
+func (b *stopRecordingBrowser) Stop() { b.onStop() }
+
+func TestDrainOnShutdown(t *testing.T) {
+	events := []string{}
+	newAdvertiser := func(name string, drain time.Duration) *MdnsAdvertise {
+		a := NewMdnsAdvertise(name, "_drain._udp", 1053, 60)
+		a.SetDrain(drain)
+		a.started = true // registered nothing, so the goodbye sends nothing
+		advertisers.acquire(t.Name()+name, a)
+		return a
+	}
+	short := newAdvertiser("short", 2*time.Second)
+	long := newAdvertiser("long", 5*time.Second)
+	undrained := newAdvertiser("undrained", 0)
+	browsers.acquire(t.Name(), &stopRecordingBrowser{onStop: func() { events = append(events, "browser stopped") }})
+
+	defer func(sleep func(time.Duration)) { drainSleep = sleep }(drainSleep)
+	drainSleep = func(d time.Duration) {
+		if short.advertising() || long.advertising() {
+			t.Error("Expected the goodbye to be sent before draining")
+		}
+		if !undrained.advertising() {
+			t.Error("Expected advertisements without a drain period to be left to the shutdown")
+		}
+		events = append(events, "drained "+d.String())
+	}
+
+	// Other events, e.g. of a reload, do not drain.
+	drainOnShutdown(caddy.InstanceStartupEvent, nil)
+	if !short.advertising() || len(events) != 0 {
+		t.Fatalf("Expected no drain on other events, got %v", events)
+	}
+
+	drainOnShutdown(caddy.ShutdownEvent, "SIGTERM")
+	// Caddy runs the shutdown callbacks after the event hooks returned.
+	for _, name := range []string{"short", "long", "undrained"} {
+		advertisers.release(t.Name() + name)
+	}
+	browsers.release(t.Name())
+
+	// The periods overlap rather than add up.
+	expected := []string{"drained 5s", "browser stopped"}
+	if len(events) != len(expected) || events[0] != expected[0] || events[1] != expected[1] {
+		t.Errorf("Unexpected shutdown order: got %v, want %v", events, expected)
+	}
+	if undrained.advertising() {
+		t.Error("Expected the shutdown to stop the remaining advertisements")
+	}
+}
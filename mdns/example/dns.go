@@ -25,10 +25,11 @@ var directives = []string{
 	"debug",
 	"errors",
 	"log",
+	"dnsmesh_mdns_advertise",
 	"cache",
 	"hosts",
 	"dnsmesh_mdns_forward",
-	"dnsmesh_mdns_advertise",
+	"dnsmesh_mdns_proxy",
 	"forward",
 	"whoami",
 	"view",
@@ -0,0 +1,191 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// HealthCheck checks whether this node is able to serve queries. The
+// advertisement is withdrawn while the check fails.
+type HealthCheck interface {
+	Check(ctx context.Context) error
+}
+
+// dnsHealthCheck resolves a canary name through the server's own listener
+// and passes when the answer is NOERROR.
+type dnsHealthCheck struct {
+	name  string
+	qtype uint16
+	addr  string // set on startup, once the listen addresses are known
+}
+
+func (h *dnsHealthCheck) Check(ctx context.Context) error {
+	req := new(dns.Msg).SetQuestion(h.name, h.qtype)
+	client := &dns.Client{Net: "udp"}
+	resp, _, err := client.ExchangeContext(ctx, req, h.addr)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("resolving %s %s returned %s", h.name, dns.TypeToString[h.qtype], dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+func (h *dnsHealthCheck) String() string {
+	return fmt.Sprintf("dns %s %s", h.name, dns.TypeToString[h.qtype])
+}
+
+// httpHealthCheck polls a URL, e.g. of the health or ready plugin, and passes
+// on a 2xx status.
+type httpHealthCheck struct {
+	url string
+}
+
+func (h *httpHealthCheck) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", h.url, resp.Status)
+	}
+	return nil
+}
+
+func (h *httpHealthCheck) String() string {
+	return "http " + h.url
+}
+
+// localDNSAddr returns the address a health check reaches the server's plain
+// DNS listener on. Wildcard listen hosts are reached over loopback.
+func localDNSAddr(listen []string, defaultPort int) string {
+	for _, addr := range listen {
+		hostPort, ok := strings.CutPrefix(addr, "dns://")
+		if !ok {
+			continue
+		}
+		host, port, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			if ip != nil && ip.To4() == nil {
+				host = "::1"
+			} else {
+				host = "127.0.0.1"
+			}
+		}
+		return net.JoinHostPort(host, port)
+	}
+	return net.JoinHostPort("127.0.0.1", fmt.Sprint(defaultPort))
+}
+
+// hysteresis tracks the health state from consecutive check results, so that
+// a single failure or success does not flap the advertisement.
+type hysteresis struct {
+	failures  int // consecutive failures before becoming unhealthy
+	successes int // consecutive successes before becoming healthy again
+
+	healthy bool
+	streak  int // consecutive results contradicting the current state
+}
+
+func newHysteresis(failures, successes int) *hysteresis {
+	return &hysteresis{failures: failures, successes: successes, healthy: true}
+}
+
+// observe records a check result and reports whether the state changed.
+func (h *hysteresis) observe(ok bool) bool {
+	if ok == h.healthy {
+		h.streak = 0
+		return false
+	}
+	h.streak++
+	threshold := h.failures
+	if !h.healthy {
+		threshold = h.successes
+	}
+	if h.streak < threshold {
+		return false
+	}
+	h.healthy = ok
+	h.streak = 0
+	return true
+}
+
+// healthLoop runs the health check every interval and withdraws or
+// re-announces the advertisement as the health state changes.
+func (m *MdnsAdvertise) healthLoop(check HealthCheck, interval time.Duration, state *hysteresis, stopCh chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), min(interval, DefaultHealthTimeout))
+		err := check.Check(ctx)
+		cancel()
+
+		if state.observe(err == nil) {
+			if state.healthy {
+				log.Infof("Health check recovered, announcing %s", m.instanceName)
+			} else {
+				log.Warningf("Health check failed, withdrawing %s: %s", m.instanceName, err)
+			}
+		} else if err != nil {
+			log.Debugf("Health check failed: %s", err)
+		}
+		m.applyHealth(state.healthy, stopCh)
+	}
+}
+
+// applyHealth registers or withdraws the advertisement to match the health
+// state. A failed registration is retried on the next check. Nothing is done
+// once the health loop identified by stopCh has been stopped.
+func (m *MdnsAdvertise) applyHealth(healthy bool, stopCh chan struct{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.healthStopCh != stopCh {
+		return
+	}
+	m.withdrawn = !healthy
+	if !healthy && m.registrations != nil {
+		m.unregister()
+	}
+	if !healthy || m.registrations != nil {
+		return
+	}
+
+	ifaces, err := m.advertisedInterfaces()
+	if err != nil {
+		// Without the bind subnet the interface watch registers once it appears.
+		if !errors.Is(err, errNoBindInterface) {
+			log.Errorf("Error re-announcing advertisement: %s", err)
+		}
+		return
+	}
+	peers := m.probeUnlocked(ifaces)
+	if m.healthStopCh != stopCh || m.withdrawn || m.registrations != nil {
+		return
+	}
+	if err := m.register(ifaces, peers); err != nil {
+		log.Errorf("Error re-announcing advertisement: %s", err)
+	}
+}
@@ -0,0 +1,206 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestHysteresis(t *testing.T) {
+	h := newHysteresis(3, 2)
+
+	steps := []struct {
+		ok      bool
+		changed bool
+		healthy bool
+	}{
+		{ok: false, healthy: true},
+		{ok: false, healthy: true},
+		{ok: true, healthy: true}, // a success resets the failure streak
+		{ok: false, healthy: true},
+		{ok: false, healthy: true},
+		{ok: false, changed: true, healthy: false},
+		{ok: true, healthy: false},
+		{ok: false, healthy: false},
+		{ok: true, healthy: false},
+		{ok: true, changed: true, healthy: true},
+	}
+
+	for i, step := range steps {
+		changed := h.observe(step.ok)
+		if changed != step.changed || h.healthy != step.healthy {
+			t.Fatalf("Step %d: expected changed=%v healthy=%v, got changed=%v healthy=%v",
+				i, step.changed, step.healthy, changed, h.healthy)
+		}
+	}
+}
+
+func TestApplyHealth(t *testing.T) {
+	a := NewMdnsAdvertise("meshdns-health", "_health._udp", 1053, 60)
+	probes := 0
+	a.probe = func(ctx context.Context, service string, ifaces []net.Interface) ([]peerInstance, error) {
+		probes++
+		return nil, nil
+	}
+	servers := []*fakeServer{}
+	a.registrar = func(instance, service, domain string, port int, text []string, ifaces []net.Interface, ttl uint32) (serviceServer, error) {
+		server := &fakeServer{}
+		servers = append(servers, server)
+		return server, nil
+	}
+	check := &fakeCheck{ready: make(chan struct{}), results: make(chan error), stop: make(chan struct{})}
+	a.SetHealthCheck(check, time.Millisecond, 2, 1)
+
+	if err := a.StartAdvertise(); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	defer close(check.stop)
+	defer a.StopAdvertise()
+	<-check.ready
+	// step runs one health check, and waits until its result is applied.
+	step := func(err error) {
+		check.results <- err
+		<-check.ready
+	}
+
+	step(nil)
+	step(errors.New("unhealthy"))
+	if a.withdrawn || a.registrations == nil {
+		t.Fatal("Expected a single failure to keep the advertisement")
+	}
+	step(errors.New("unhealthy"))
+	if !a.withdrawn || a.registrations != nil {
+		t.Fatal("Expected the advertisement to be withdrawn")
+	}
+	if len(servers) != 1 || !servers[0].shutdown {
+		t.Fatalf("Expected a goodbye for the registration, got %d registrations", len(servers))
+	}
+
+	// The interface watch leaves a withdrawn advertisement alone.
+	a.checkInterfaces(a.watchStopCh)
+	if a.registrations != nil || len(servers) != 1 {
+		t.Fatal("Expected the interface watch not to register while withdrawn")
+	}
+
+	probesBefore := probes
+	step(nil)
+	if a.withdrawn || a.registrations == nil || len(servers) != 2 {
+		t.Fatalf("Expected the advertisement to be restored, got %d registrations", len(servers))
+	}
+	if probes == probesBefore {
+		t.Error("Expected a probe for conflicts before announcing again")
+	}
+	if servers[1].shutdown {
+		t.Error("Expected the restored registration to be running")
+	}
+
+	// Once restored, the unchanged interfaces are not registered again.
+	a.checkInterfaces(a.watchStopCh)
+	if len(servers) != 2 {
+		t.Errorf("Expected no new registration, got %d registrations", len(servers))
+	}
+}
+
+// fakeCheck returns the results sent by the test, announcing each check on
+// ready before waiting for its result.
+type fakeCheck struct {
+	ready   chan struct{}
+	results chan error
+	stop    chan struct{}
+}
+
+func (c *fakeCheck) Check(ctx context.Context) error {
+	select {
+	case c.ready <- struct{}{}:
+	case <-c.stop:
+		return errors.New("stopped")
+	}
+	select {
+	case err := <-c.results:
+		return err
+	case <-c.stop:
+		return errors.New("stopped")
+	}
+}
+
+func (c *fakeCheck) String() string { return "fake check" }
+
+// fakeServer records the goodbye of a registration.
+type fakeServer struct {
+	text     []string
+	shutdown bool
+}
+
+func (s *fakeServer) SetText(text []string) { s.text = text }
+func (s *fakeServer) Shutdown()             { s.shutdown = true }
+
+func TestLocalDNSAddr(t *testing.T) {
+	testCases := []struct {
+		name     string
+		listen   []string
+		expected string
+	}{
+		{name: "no listen addresses", expected: "127.0.0.1:53"},
+		{name: "all addresses", listen: []string{"dns://:1053"}, expected: "127.0.0.1:1053"},
+		{name: "unspecified ipv4", listen: []string{"dns://0.0.0.0:1053"}, expected: "127.0.0.1:1053"},
+		{name: "unspecified ipv6", listen: []string{"dns://[::]:1053"}, expected: "[::1]:1053"},
+		{name: "bound address", listen: []string{"dns://192.168.2.5:1053"}, expected: "192.168.2.5:1053"},
+		{name: "skips other transports", listen: []string{"tls://:853", "dns://10.0.0.1:53"}, expected: "10.0.0.1:53"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if addr := localDNSAddr(tc.listen, 53); addr != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, addr)
+			}
+		})
+	}
+}
+
+func TestDNSHealthCheck(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg).SetReply(r)
+		if r.Question[0].Name != "canary.example.com." {
+			m.Rcode = dns.RcodeServerFailure
+		}
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	check := &dnsHealthCheck{name: "canary.example.com.", qtype: dns.TypeA, addr: pc.LocalAddr().String()}
+	if err := check.Check(context.Background()); err != nil {
+		t.Errorf("Expected the check to pass, got: %v", err)
+	}
+	check.name = "broken.example.com."
+	if err := check.Check(context.Background()); err == nil {
+		t.Error("Expected the check to fail on SERVFAIL")
+	}
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	check := &httpHealthCheck{url: server.URL}
+	if err := check.Check(context.Background()); err != nil {
+		t.Errorf("Expected the check to pass, got: %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := check.Check(context.Background()); err == nil {
+		t.Error("Expected the check to fail on 503")
+	}
+}
@@ -0,0 +1,335 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// HostRecordTTL is the TTL of published host records, as recommended by
+// RFC 6762 section 10.
+const HostRecordTTL uint32 = 120
+
+// legacyUnicastTTL caps the TTL of answers to legacy unicast queries, see
+// RFC 6762 section 6.7.
+const legacyUnicastTTL uint32 = 10
+
+// mdnsGroupIPv6 is the IPv6 mDNS multicast address.
+var mdnsGroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+
+// hostName returns the fully qualified .local name of a host name, e.g.
+// "nas" and "nas.local" both become "nas.local.".
+func hostName(name string) string {
+	name = dns.Fqdn(strings.ToLower(name))
+	if !strings.HasSuffix(name, "."+strings.Trim(DefaultDomain, ".")+".") {
+		name += DefaultDomain
+	}
+	return name
+}
+
+// hostResponder answers mDNS queries for the A and AAAA records of host
+// names. zeroconf only publishes the host name of a service as part of its
+// answers and never answers address queries for it, so devices looking up a
+// name directly would not find it.
+type hostResponder struct {
+	records map[string][]net.IP // fully qualified name to addresses
+	ifaces  []net.Interface
+
+	ipv4conn *ipv4.PacketConn
+	ipv6conn *ipv6.PacketConn
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newHostResponder(records map[string][]net.IP, ifaces []net.Interface) *hostResponder {
+	return &hostResponder{records: records, ifaces: ifaces}
+}
+
+// start joins the mDNS groups on the interfaces, answers queries and
+// announces the records.
+func (h *hostResponder) start() error {
+	if h.ifaces == nil {
+		ifaces, err := multicastInterfaces()
+		if err != nil {
+			return err
+		}
+		h.ifaces = ifaces
+	}
+
+	if conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(224, 0, 0, 0), Port: 5353}); err == nil {
+		pc := ipv4.NewPacketConn(conn)
+		pc.SetControlMessage(ipv4.FlagInterface, true)
+		pc.SetMulticastTTL(255)
+		joined := false
+		for _, iface := range h.ifaces {
+			if pc.JoinGroup(&iface, &net.UDPAddr{IP: mdnsGroupIPv4.IP}) == nil {
+				joined = true
+			}
+		}
+		if joined {
+			h.ipv4conn = pc
+		} else {
+			pc.Close()
+		}
+	}
+	if conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.ParseIP("ff02::"), Port: 5353}); err == nil {
+		pc := ipv6.NewPacketConn(conn)
+		pc.SetControlMessage(ipv6.FlagInterface, true)
+		pc.SetMulticastHopLimit(255)
+		joined := false
+		for _, iface := range h.ifaces {
+			if pc.JoinGroup(&iface, &net.UDPAddr{IP: mdnsGroupIPv6.IP}) == nil {
+				joined = true
+			}
+		}
+		if joined {
+			h.ipv6conn = pc
+		} else {
+			pc.Close()
+		}
+	}
+	if h.ipv4conn == nil && h.ipv6conn == nil {
+		return fmt.Errorf("failed to join the mDNS groups on %d interfaces", len(h.ifaces))
+	}
+
+	h.stopCh = make(chan struct{})
+	if h.ipv4conn != nil {
+		h.wg.Add(1)
+		go h.recv4()
+	}
+	if h.ipv6conn != nil {
+		h.wg.Add(1)
+		go h.recv6()
+	}
+	h.wg.Add(1)
+	go h.announce()
+	return nil
+}
+
+// stop sends a goodbye for the records and closes the connections.
+func (h *hostResponder) stop() {
+	close(h.stopCh)
+	h.multicast(h.response(h.names(), 0, true), 0)
+	if h.ipv4conn != nil {
+		h.ipv4conn.Close()
+	}
+	if h.ipv6conn != nil {
+		h.ipv6conn.Close()
+	}
+	h.wg.Wait()
+}
+
+// announce sends the records twice, one second apart, see RFC 6762
+// section 8.3.
+func (h *hostResponder) announce() {
+	defer h.wg.Done()
+	for i := 0; i < 2; i++ {
+		h.multicast(h.response(h.names(), HostRecordTTL, true), 0)
+		select {
+		case <-h.stopCh:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (h *hostResponder) names() []string {
+	names := []string{}
+	for name := range h.records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (h *hostResponder) recv4() {
+	defer h.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, cm, from, err := h.ipv4conn.ReadFrom(buf)
+		if err != nil {
+			return // closed
+		}
+		ifIndex := 0
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+		h.handle(buf[:n], ifIndex, from)
+	}
+}
+
+func (h *hostResponder) recv6() {
+	defer h.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, cm, from, err := h.ipv6conn.ReadFrom(buf)
+		if err != nil {
+			return // closed
+		}
+		ifIndex := 0
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+		h.handle(buf[:n], ifIndex, from)
+	}
+}
+
+// handle answers a query received on an interface.
+func (h *hostResponder) handle(packet []byte, ifIndex int, from net.Addr) {
+	query := new(dns.Msg)
+	if query.Unpack(packet) != nil || query.Response || query.Opcode != dns.OpcodeQuery {
+		return
+	}
+	udpFrom, ok := from.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	legacy := udpFrom.Port != 5353
+
+	unicast := legacy
+	names := []string{}
+	for _, q := range query.Question {
+		if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA && q.Qtype != dns.TypeANY {
+			continue
+		}
+		name := strings.ToLower(q.Name)
+		if _, ok := h.records[name]; !ok {
+			continue
+		}
+		names = append(names, name)
+		if q.Qclass&(1<<15) != 0 {
+			unicast = true
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	ttl := HostRecordTTL
+	if legacy {
+		ttl = legacyUnicastTTL
+	}
+	resp := h.response(names, ttl, !legacy)
+	if legacy {
+		// Legacy resolvers expect the ID and question of their query.
+		resp.Id = query.Id
+		resp.Question = query.Question
+	}
+	if unicast {
+		h.unicast(resp, ifIndex, udpFrom)
+	} else {
+		h.multicast(resp, ifIndex)
+	}
+}
+
+// response builds an authoritative response with the records of the names.
+// With ttl 0 it is a goodbye. Legacy unicast answers must not set the cache
+// flush bit.
+func (h *hostResponder) response(names []string, ttl uint32, flush bool) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Authoritative = true
+	class := uint16(dns.ClassINET)
+	if flush {
+		class |= 1 << 15 // cache flush, the records are unique to this responder
+	}
+	for _, name := range names {
+		for _, ip := range h.records[name] {
+			if ip4 := ip.To4(); ip4 != nil {
+				resp.Answer = append(resp.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: class, Ttl: ttl},
+					A:   ip4,
+				})
+			} else {
+				resp.Answer = append(resp.Answer, &dns.AAAA{
+					Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: class, Ttl: ttl},
+					AAAA: ip,
+				})
+			}
+		}
+	}
+	return resp
+}
+
+// multicast sends a response to the mDNS groups, on one interface or on all
+// of them when ifIndex is 0.
+func (h *hostResponder) multicast(resp *dns.Msg, ifIndex int) {
+	buf, err := resp.Pack()
+	if err != nil {
+		log.Errorf("Failed to pack host records: %s", err)
+		return
+	}
+	for _, iface := range h.ifaces {
+		if ifIndex != 0 && iface.Index != ifIndex {
+			continue
+		}
+		if h.ipv4conn != nil {
+			h.ipv4conn.WriteTo(buf, &ipv4.ControlMessage{IfIndex: iface.Index}, mdnsGroupIPv4)
+		}
+		if h.ipv6conn != nil {
+			h.ipv6conn.WriteTo(buf, &ipv6.ControlMessage{IfIndex: iface.Index}, mdnsGroupIPv6)
+		}
+	}
+}
+
+// unicast sends a response directly to the querier.
+func (h *hostResponder) unicast(resp *dns.Msg, ifIndex int, to *net.UDPAddr) {
+	buf, err := resp.Pack()
+	if err != nil {
+		log.Errorf("Failed to pack host records: %s", err)
+		return
+	}
+	if to.IP.To4() != nil {
+		if h.ipv4conn != nil {
+			h.ipv4conn.WriteTo(buf, &ipv4.ControlMessage{IfIndex: ifIndex}, to)
+		}
+	} else if h.ipv6conn != nil {
+		h.ipv6conn.WriteTo(buf, &ipv6.ControlMessage{IfIndex: ifIndex}, to)
+	}
+}
+
+// AddHost publishes A and AAAA records for a host name in .local, e.g. "nas"
+// or "nas.local", while advertising. Addresses for the same name add up.
+func (m *MdnsAdvertise) AddHost(name string, ips ...net.IP) {
+	if m.hostRecords == nil {
+		m.hostRecords = make(map[string][]net.IP)
+	}
+	name = hostName(name)
+	m.hostRecords[name] = append(m.hostRecords[name], ips...)
+}
+
+// updateHosts runs the host responder on the interfaces, restarting it when
+// they changed. Host records do not depend on the health of the DNS
+// service, so they are published while the service is withdrawn. The caller
+// must hold the mutex.
+func (m *MdnsAdvertise) updateHosts(ifaces []net.Interface, state string) {
+	if len(m.hostRecords) == 0 || (m.hosts != nil && m.hostsState == state) {
+		return
+	}
+	m.stopHosts()
+
+	hosts := newHostResponder(m.hostRecords, ifaces)
+	if err := hosts.start(); err != nil {
+		log.Errorf("Failed to publish host records: %s", err)
+		return
+	}
+	log.Infof("Publishing host records for %s", strings.Join(hosts.names(), ", "))
+	m.hosts = hosts
+	m.hostsState = state
+}
+
+// stopHosts sends a goodbye for the host records. The caller must hold the
+// mutex.
+func (m *MdnsAdvertise) stopHosts() {
+	if m.hosts != nil {
+		m.hosts.stop()
+		m.hosts = nil
+	}
+}
@@ -0,0 +1,82 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHostName(t *testing.T) {
+	testCases := map[string]string{
+		"nas":         "nas.local.",
+		"NAS.local":   "nas.local.",
+		"nas.local.":  "nas.local.",
+		"printer.lan": "printer.lan.local.",
+	}
+	for name, expected := range testCases {
+		if got := hostName(name); got != expected {
+			t.Errorf("hostName(%q): expected %q, got %q", name, expected, got)
+		}
+	}
+}
+
+func TestHostResponse(t *testing.T) {
+	h := newHostResponder(map[string][]net.IP{
+		"nas.local.":     {net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")},
+		"printer.local.": {net.ParseIP("192.168.1.20")},
+	}, nil)
+
+	resp := h.response([]string{"nas.local."}, HostRecordTTL, true)
+	if !resp.Response || !resp.Authoritative {
+		t.Error("Expected an authoritative response")
+	}
+	if len(resp.Answer) != 2 {
+		t.Fatalf("Expected 2 answers, got %d", len(resp.Answer))
+	}
+	a, ok := resp.Answer[0].(*dns.A)
+	if !ok || !a.A.Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("Expected an A record for 192.168.1.10, got %s", resp.Answer[0])
+	}
+	aaaa, ok := resp.Answer[1].(*dns.AAAA)
+	if !ok || !aaaa.AAAA.Equal(net.ParseIP("fd00::10")) {
+		t.Errorf("Expected an AAAA record for fd00::10, got %s", resp.Answer[1])
+	}
+	for _, rr := range resp.Answer {
+		if rr.Header().Class != dns.ClassINET|1<<15 {
+			t.Errorf("Expected the cache flush bit on %s", rr)
+		}
+		if rr.Header().Ttl != HostRecordTTL {
+			t.Errorf("Expected TTL %d on %s", HostRecordTTL, rr)
+		}
+	}
+
+	legacy := h.response([]string{"printer.local."}, legacyUnicastTTL, false)
+	if len(legacy.Answer) != 1 || legacy.Answer[0].Header().Class != dns.ClassINET {
+		t.Errorf("Expected one answer without the cache flush bit, got %v", legacy.Answer)
+	}
+
+	goodbye := h.response(h.names(), 0, true)
+	if len(goodbye.Answer) != 3 {
+		t.Fatalf("Expected 3 answers, got %d", len(goodbye.Answer))
+	}
+	for _, rr := range goodbye.Answer {
+		if rr.Header().Ttl != 0 {
+			t.Errorf("Expected TTL 0 in a goodbye, got %s", rr)
+		}
+	}
+}
+
+func TestAddHost(t *testing.T) {
+	m := NewMdnsAdvertise("node", "_dns._udp", 53, 120)
+	before := m.key()
+	m.AddHost("nas", net.ParseIP("192.168.1.10"))
+	m.AddHost("NAS.local", net.ParseIP("fd00::10"))
+
+	if ips := m.hostRecords["nas.local."]; len(ips) != 2 {
+		t.Errorf("Expected 2 addresses for nas.local., got %v", ips)
+	}
+	if m.key() == before {
+		t.Error("Expected host records to change the key")
+	}
+}
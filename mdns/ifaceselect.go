@@ -0,0 +1,119 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/coredns/caddy"
+)
+
+// interfaceSelector picks the interfaces to advertise and browse on, from the
+// iface_bind_subnet, iface and exclude_iface options.
+type interfaceSelector struct {
+	subnets  []*net.IPNet // interfaces with an address in one of these subnets
+	names    []string     // interface names or glob patterns
+	excludes []string     // interface names or glob patterns to skip
+}
+
+// empty reports whether no selection is configured, in which case zeroconf
+// uses all multicast interfaces.
+func (s *interfaceSelector) empty() bool {
+	return len(s.subnets) == 0 && len(s.names) == 0 && len(s.excludes) == 0
+}
+
+// interfaces returns the selected interfaces: those in one of the subnets or
+// matching one of the names, or all multicast interfaces when neither is
+// configured, without the excluded ones.
+func (s *interfaceSelector) interfaces(findIfaces interfaceFinder) ([]net.Interface, error) {
+	candidates := []net.Interface{}
+	for _, subnet := range s.subnets {
+		found, err := findIfaces(*subnet)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, found...)
+	}
+	if len(s.names) > 0 {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range all {
+			if matchesInterface(s.names, iface.Name) {
+				candidates = append(candidates, iface)
+			}
+		}
+	}
+	if len(s.subnets) == 0 && len(s.names) == 0 {
+		all, err := multicastInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		candidates = all
+	}
+
+	selected := []net.Interface{}
+	seen := make(map[string]bool)
+	for _, iface := range candidates {
+		if seen[iface.Name] || matchesInterface(s.excludes, iface.Name) {
+			continue
+		}
+		seen[iface.Name] = true
+		selected = append(selected, iface)
+	}
+	return selected, nil
+}
+
+func (s *interfaceSelector) String() string {
+	subnets := []string{}
+	for _, subnet := range s.subnets {
+		subnets = append(subnets, subnet.String())
+	}
+	return fmt.Sprintf("subnets=%s names=%s excludes=%s",
+		strings.Join(subnets, ","), strings.Join(s.names, ","), strings.Join(s.excludes, ","))
+}
+
+// matchesInterface reports whether name matches one of the names or glob
+// patterns.
+func matchesInterface(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseInterfaceOption parses the iface_bind_subnet, iface and exclude_iface
+// options into the selector. Each may be repeated and takes one or more
+// arguments.
+func parseInterfaceOption(c *caddy.Controller, s *interfaceSelector) error {
+	optionName := c.Val()
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return c.Errf("option '%s' expects at least one argument", optionName)
+	}
+
+	for _, arg := range args {
+		switch optionName {
+		case "iface_bind_subnet":
+			_, subnet, err := net.ParseCIDR(arg)
+			if err != nil {
+				return c.Errf("failed to parse subnet for '%s': %s", optionName, arg)
+			}
+			s.subnets = append(s.subnets, subnet)
+		case "iface", "exclude_iface":
+			if _, err := path.Match(arg, ""); err != nil {
+				return c.Errf("invalid interface pattern for '%s': %s", optionName, arg)
+			}
+			if optionName == "iface" {
+				s.names = append(s.names, arg)
+			} else {
+				s.excludes = append(s.excludes, arg)
+			}
+		}
+	}
+	return nil
+}
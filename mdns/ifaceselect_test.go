@@ -0,0 +1,124 @@
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func interfaceNames(ifaces []net.Interface) []string {
+	names := []string{}
+	for _, iface := range ifaces {
+		names = append(names, iface.Name)
+	}
+	return names
+}
+
+func TestInterfaceSelector(t *testing.T) {
+	bySubnet := map[string][]net.Interface{
+		"192.168.1.0/24": {{Name: "eth0"}},
+		"10.20.0.0/16":   {{Name: "vlan20"}},
+		"10.0.0.0/8":     {{Name: "vlan20"}, {Name: "wg0"}},
+	}
+	finder := func(subnet net.IPNet) ([]net.Interface, error) {
+		return bySubnet[subnet.String()], nil
+	}
+	subnet := func(cidr string) *net.IPNet {
+		_, n, _ := net.ParseCIDR(cidr)
+		return n
+	}
+
+	testCases := []struct {
+		name     string
+		selector interfaceSelector
+		expected []string
+	}{
+		{
+			name:     "several subnets",
+			selector: interfaceSelector{subnets: []*net.IPNet{subnet("192.168.1.0/24"), subnet("10.20.0.0/16")}},
+			expected: []string{"eth0", "vlan20"},
+		},
+		{
+			name:     "overlapping subnets",
+			selector: interfaceSelector{subnets: []*net.IPNet{subnet("10.20.0.0/16"), subnet("10.0.0.0/8")}},
+			expected: []string{"vlan20", "wg0"},
+		},
+		{
+			name:     "excluded glob",
+			selector: interfaceSelector{subnets: []*net.IPNet{subnet("10.0.0.0/8")}, excludes: []string{"wg*"}},
+			expected: []string{"vlan20"},
+		},
+		{
+			name:     "no match",
+			selector: interfaceSelector{subnets: []*net.IPNet{subnet("172.16.0.0/12")}},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ifaces, err := tc.selector.interfaces(finder)
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if names := interfaceNames(ifaces); !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, names)
+			}
+		})
+	}
+}
+
+func TestInterfaceSelectorByName(t *testing.T) {
+	all, err := net.Interfaces()
+	if err != nil || len(all) == 0 {
+		t.Skip("No interfaces available")
+	}
+	name := all[0].Name
+
+	s := interfaceSelector{names: []string{name[:1] + "*"}}
+	ifaces, err := s.interfaces(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if !slices.Contains(interfaceNames(ifaces), name) {
+		t.Errorf("Expected %s to be selected by glob, got %v", name, interfaceNames(ifaces))
+	}
+
+	s.excludes = []string{name}
+	ifaces, err = s.interfaces(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if slices.Contains(interfaceNames(ifaces), name) {
+		t.Errorf("Expected %s to be excluded, got %v", name, interfaceNames(ifaces))
+	}
+}
+
+func TestParseInterfaceOption(t *testing.T) {
+	c := caddy.NewTestController("dns", `dnsmesh_mdns_advertise {
+		iface_bind_subnet 192.168.1.0/24 10.20.0.0/16
+		iface_bind_subnet fd00::/64
+		iface eth0 wg*
+		exclude_iface docker*
+	}`)
+	c.Next()
+	s := interfaceSelector{}
+	for c.NextBlock() {
+		if err := parseInterfaceOption(c, &s); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	if len(s.subnets) != 3 || s.subnets[2].String() != "fd00::/64" {
+		t.Errorf("Unexpected subnets: %v", s.subnets)
+	}
+	if !reflect.DeepEqual(s.names, []string{"eth0", "wg*"}) {
+		t.Errorf("Unexpected names: %v", s.names)
+	}
+	if !reflect.DeepEqual(s.excludes, []string{"docker*"}) {
+		t.Errorf("Unexpected excludes: %v", s.excludes)
+	}
+}
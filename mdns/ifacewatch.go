@@ -0,0 +1,125 @@
+package mdns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errNoBindInterface is returned while no interface matches the interface
+// selection, e.g. because DHCP has not finished yet.
+var errNoBindInterface = errors.New("no interface matches the interface selection")
+
+// multicastInterfaces returns the interfaces zeroconf announces on when it is
+// not given any.
+func multicastInterfaces() ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	ifaces := []net.Interface{}
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	return ifaces, nil
+}
+
+// advertisedInterfaces returns the interfaces to announce on, or nil for all
+// multicast interfaces when no interfaces are selected.
+func (m *MdnsAdvertise) advertisedInterfaces() ([]net.Interface, error) {
+	if m.ifaceSelector.empty() {
+		return nil, nil
+	}
+	ifaces, err := m.ifaceSelector.interfaces(FindInterfacesForSubnet)
+	if err != nil {
+		return nil, err
+	}
+	if len(ifaces) == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoBindInterface, &m.ifaceSelector)
+	}
+	return ifaces, nil
+}
+
+// interfaceState describes the interfaces and their addresses, so that a
+// change to either can be detected. nil describes all multicast interfaces.
+func interfaceState(ifaces []net.Interface) string {
+	if ifaces == nil {
+		ifaces, _ = multicastInterfaces()
+	}
+	states := []string{}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		addrStrs := []string{}
+		for _, addr := range addrs {
+			addrStrs = append(addrStrs, addr.String())
+		}
+		sort.Strings(addrStrs)
+		states = append(states, fmt.Sprintf("%s#%d=%s", iface.Name, iface.Index, strings.Join(addrStrs, ",")))
+	}
+	sort.Strings(states)
+	return strings.Join(states, ";")
+}
+
+// watchInterfaces polls the interfaces every InterfacePollInterval and
+// re-registers the advertisement when they change.
+func (m *MdnsAdvertise) watchInterfaces(stopCh chan struct{}) {
+	ticker := time.NewTicker(InterfacePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			m.checkInterfaces(stopCh)
+		}
+	}
+}
+
+// checkInterfaces registers the advertisement once a selected interface
+// shows up, withdraws it when none is left and re-registers it when the
+// interfaces or their addresses change. Nothing is done once the watch
+// identified by stopCh has been stopped. The host records follow the
+// interfaces even while the health check has withdrawn the advertisement.
+func (m *MdnsAdvertise) checkInterfaces(stopCh chan struct{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.watchStopCh != stopCh {
+		return
+	}
+
+	ifaces, err := m.advertisedInterfaces()
+	if err != nil {
+		m.stopHosts()
+		if m.registrations != nil {
+			log.Warningf("Withdrawing advertisement: %s", err)
+			m.unregister()
+		}
+		return
+	}
+	state := interfaceState(ifaces)
+	m.updateHosts(ifaces, state)
+
+	if m.withdrawn || (m.registrations != nil && state == m.ifaceState) {
+		return
+	}
+	peers := m.probeUnlocked(ifaces)
+	if m.watchStopCh != stopCh || m.withdrawn || (m.registrations != nil && state == m.ifaceState) {
+		return
+	}
+
+	if m.registrations != nil {
+		log.Infof("Interfaces changed, re-registering advertisement")
+		m.unregister()
+	} else {
+		log.Infof("Interfaces available, registering advertisement")
+	}
+	if err := m.register(ifaces, peers); err != nil {
+		log.Errorf("Error registering advertisement: %s", err)
+	}
+}
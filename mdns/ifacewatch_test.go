@@ -0,0 +1,55 @@
+package mdns
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestStartAdvertiseWaitsForBindSubnet(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("203.0.113.0/24") // TEST-NET-3, never assigned
+	a := NewMdnsAdvertise("instance", "_wait._udp", 1053, 60)
+	a.BindToSubnet(subnet)
+
+	if _, err := a.advertisedInterfaces(); !errors.Is(err, errNoBindInterface) {
+		t.Fatalf("Expected errNoBindInterface, got: %v", err)
+	}
+
+	if err := a.StartAdvertise(); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if !a.advertising() {
+		t.Error("Expected the advertisement to be started")
+	}
+	if a.registrations != nil {
+		t.Error("Expected no registration without the bind subnet")
+	}
+
+	// Polling keeps waiting without registering.
+	a.checkInterfaces(a.watchStopCh)
+	if a.registrations != nil {
+		t.Error("Expected no registration without the bind subnet")
+	}
+
+	a.StopAdvertise()
+	if a.advertising() || a.watchStopCh != nil {
+		t.Error("Expected the advertisement and its watch to be stopped")
+	}
+}
+
+func TestInterfaceState(t *testing.T) {
+	ifaces, err := multicastInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interfaceState(nil) != interfaceState(ifaces) {
+		t.Error("Expected nil to describe all multicast interfaces")
+	}
+	reversed := make([]net.Interface, len(ifaces))
+	for i, iface := range ifaces {
+		reversed[len(ifaces)-1-i] = iface
+	}
+	if interfaceState(reversed) != interfaceState(ifaces) {
+		t.Error("Expected the state to not depend on the interface order")
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/grandcat/zeroconf"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/request"
 	"github.com/networkservicemesh/fanout"
 
 	"github.com/nbeirne/coredns-dnsmesh/mdns/browser"
@@ -28,7 +30,9 @@ const (
 type MdnsForwardPlugin struct {
 	// fanout
 	Timeout     time.Duration  // overall timeout for a whole request
+	PeerTimeout time.Duration  // timeout for a single peer
 	Zone        string         // only process requests to this domain
+	Mesh        string         // only forward to peers advertising this mesh namespace
 	Attempts    int            // attempts per server
 	WorkerCount int            // number of requests to run in parallel
 	Next        plugin.Handler // next plugin if req not in zone or it is an excluded domains
@@ -37,10 +41,34 @@ type MdnsForwardPlugin struct {
 	// TODO: fallthrough on error?
 
 	// internal filters
-	filter       *regexp.Regexp
-	ignoreSelf   bool
-	addrMode     int
-	addrsPerHost int
+	filter         *regexp.Regexp    // instance names to include
+	exclude        *regexp.Regexp    // instance names to exclude
+	hostnameFilter *regexp.Regexp    // host names to include
+	requireTxt     map[string]string // TXT key/value pairs a peer must publish, "" only requires the key
+	allowCidrs     []netip.Prefix    // when set, only addresses in these prefixes are used
+	denyCidrs      []netip.Prefix    // addresses in these prefixes are never used
+	ignoreSelf     bool
+	addrMode       int
+	addrsPerHost   int
+
+	// per query type policies
+	allowQtypes   map[uint16]bool // when set, only these query types are forwarded
+	denyQtypes    map[uint16]bool // these query types are refused
+	minimalAny    bool            // answer ANY queries with a minimal RFC 8482 response
+	policy        int             // default fanout policy
+	qtypePolicies map[uint16]int  // fanout policy overrides per query type
+
+	refreshTimeout time.Duration // timeout of the forced mDNS refresh before retrying
+
+	// answer TTLs
+	minTTL          uint32 // raise answer TTLs to at least this value, 0 to disable
+	maxTTL          uint32 // lower answer TTLs to at most this value, 0 to disable
+	peerLifetimeTTL bool   // cap answer TTLs at the remaining mDNS lifetime of the answering peer
+
+	rateLimiter *rateLimiter // limits the queries fanned out per client and overall when set
+
+	tsig     *tsigKey      // signs peer queries and verifies their responses when set
+	verifier *peerVerifier // only accept peers with a trusted advertisement signature when set
 
 	browser browser.MdnsBrowserInterface
 
@@ -67,12 +95,22 @@ type fanoutHandler interface {
 	ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error)
 }
 
-func (m *MdnsForwardPlugin) createFanout() fanoutHandler {
+func (m *MdnsForwardPlugin) createFanout(qtype uint16) fanoutHandler {
+	return m.newFanout(m.policyFor(qtype), m.Zone, m.browser.PartitionServices(m.Mesh))
+}
+
+// createReverseFanout creates a fanout to the peers owning the subnet of a
+// reverse lookup address.
+func (m *MdnsForwardPlugin) createReverseFanout(qtype uint16, addr netip.Addr) fanoutHandler {
+	return m.newFanout(m.policyFor(qtype), reverseZone(addr), m.servicesForAddr(addr))
+}
+
+func (m *MdnsForwardPlugin) newFanout(policy int, from string, services []*zeroconf.ServiceEntry) fanoutHandler {
 	f := &fanout.Fanout{
 		Timeout:               m.Timeout,
-		ExcludeDomains:        fanout.NewDomain(), // TODO - no excludes
-		Race:                  false,              // first to respond wins, even if !success
-		From:                  m.Zone,
+		ExcludeDomains:        fanout.NewDomain(),   // TODO - no excludes
+		Race:                  policy == RacePolicy, // first to respond wins, even if !success
+		From:                  from,
 		Attempts:              m.Attempts,
 		ServerSelectionPolicy: &fanout.SequentialPolicy{},
 		Next:                  m.Next,
@@ -80,25 +118,91 @@ func (m *MdnsForwardPlugin) createFanout() fanoutHandler {
 		//TapPlugin:            *dnstap.Dnstap, // TODO: setup tap plugin
 	}
 
-	services := m.browser.Services()
+	addrs := []string{}
+	instances := make(map[string]string) // addr -> instance
 	for _, service := range services {
 		hosts := m.hostsForZeroconfServiceEntry(service)
 		for _, host := range hosts {
 			log.Infof("Forwarding query to %v instance %s: %s", service.Service, service.Instance, host.String())
-			f.AddClient(fanout.NewClient(host.String(), fanout.UDP))
+			addrs = append(addrs, host.String())
+			instances[host.String()] = service.Instance
 		}
 	}
 
+	for _, addr := range applyPolicy(policy, addrs) {
+		f.AddClient(m.newClient(addr, instances[addr]))
+	}
+
 	return f
 }
 
+func (m *MdnsForwardPlugin) newClient(addr, instance string) fanout.Client {
+	newNetClient := func(net string) fanout.Client {
+		if m.tsig != nil {
+			return newTsigClient(addr, net, m.tsig)
+		}
+		return fanout.NewClient(addr, net)
+	}
+
+	var client fanout.Client = &truncationClient{
+		Client: newNetClient(fanout.UDP),
+		tcp:    newNetClient(fanout.TCP),
+	}
+	if m.PeerTimeout > 0 {
+		client = &timeoutClient{Client: client, timeout: m.PeerTimeout}
+	}
+
+	if m.minTTL > 0 || m.maxTTL > 0 || m.peerLifetimeTTL {
+		ttl := &ttlClient{Client: client, minTTL: m.minTTL, maxTTL: m.maxTTL}
+		if m.peerLifetimeTTL {
+			ttl.expiry = func() time.Time { return m.browser.Expiry(instance) }
+		}
+		client = ttl
+	}
+	return client
+}
+
 func (m *MdnsForwardPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	log.Debugf("Received request for name: %v", r.Question[0].Name)
-	createFanout := m.createFanout
+	qtype := r.Question[0].Qtype
+	createFanout := func() fanoutHandler { return m.createFanout(qtype) }
+	inZone := plugin.Name(m.Zone).Matches(r.Question[0].Name)
+	if addr, ok := reverseAddr(r); ok && len(m.servicesForAddr(addr)) > 0 {
+		log.Debugf("Routing reverse lookup for %s to the peers owning its subnet", addr)
+		createFanout = func() fanoutHandler { return m.createReverseFanout(qtype, addr) }
+		inZone = true
+	}
 	if m.createFanoutFunc != nil {
 		createFanout = func() fanoutHandler { return m.createFanoutFunc(m) }
 	}
 
+	if inZone {
+		if !m.qtypeAllowed(qtype) {
+			log.Debugf("Refusing %s query for '%s'", dns.TypeToString[qtype], r.Question[0].Name)
+			return dns.RcodeRefused, nil
+		}
+		if qtype == dns.TypeANY && m.minimalAny {
+			return writeMinimalAny(w, r)
+		}
+		if m.rateLimiter != nil {
+			state := request.Request{W: w, Req: r}
+			client, _ := netip.ParseAddr(state.IP())
+			if limit := m.rateLimiter.allow(client); limit != "" {
+				log.Debugf("Rate limiting query for '%s' from %s: %s limit exceeded", r.Question[0].Name, client, limit)
+				rateLimitedCount.WithLabelValues(metrics.WithServer(ctx), limit).Inc()
+				if m.rateLimiter.action == FallthroughAction {
+					return plugin.NextOrFailure(m.Name(), m.Next, ctx, w, r)
+				}
+				return dns.RcodeRefused, nil
+			}
+		}
+	}
+
+	// Bound the whole request, including the retry, by the total timeout and
+	// by the deadline of the incoming context.
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
 	// First attempt
 	f := createFanout()
 	recorder := NewResponseRecorder(w)
@@ -108,8 +212,9 @@ func (m *MdnsForwardPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter,
 	// force a refresh and retry.
 	if err != nil || (recorder.Rcode != dns.RcodeSuccess && recorder.Rcode != dns.RcodeNameError) {
 		log.Warningf("Initial query for '%s' failed (rcode: %d, err: %v). Forcing mDNS refresh and retrying.", r.Question[0].Name, recorder.Rcode, err)
-		timeoutCtx, _ := context.WithTimeout(ctx, 1.0*time.Second)
-		m.browser.ForceRefresh(timeoutCtx)
+		refreshCtx, cancelRefresh := context.WithTimeout(ctx, m.refreshTimeout)
+		m.browser.ForceRefresh(refreshCtx)
+		cancelRefresh()
 
 		// Second attempt
 		f = createFanout()
@@ -127,6 +232,35 @@ func (m *MdnsForwardPlugin) hostsForZeroconfServiceEntry(entry *zeroconf.Service
 		return []netip.AddrPort{}
 	}
 
+	if m.exclude != nil && m.exclude.MatchString(entry.Instance) {
+		log.Debugf("Ignoring entry '%s' because the instance name matched the exclude filter: '%s'",
+			entry.Instance, m.exclude.String())
+		return []netip.AddrPort{}
+	}
+
+	if m.hostnameFilter != nil && !m.hostnameFilter.MatchString(entry.HostName) {
+		log.Debugf("Ignoring entry '%s' because the host name '%s' did not match the filter: '%s'",
+			entry.Instance, entry.HostName, m.hostnameFilter.String())
+		return []netip.AddrPort{}
+	}
+
+	for key, expected := range m.requireTxt {
+		val, ok := txtValue(entry.Text, key)
+		if !ok || (expected != "" && val != expected) {
+			log.Debugf("Ignoring entry '%s' because its TXT record does not have %s=%s",
+				entry.Instance, key, expected)
+			return []netip.AddrPort{}
+		}
+	}
+
+	if m.verifier != nil {
+		if err := m.verifier.verify(entry); err != nil {
+			log.Debugf("Ignoring entry '%s' because its advertisement could not be verified: %v",
+				entry.Instance, err)
+			return []netip.AddrPort{}
+		}
+	}
+
 	ips := []net.IP{}
 	switch m.addrMode {
 	case PreferIPv6:
@@ -141,8 +275,9 @@ func (m *MdnsForwardPlugin) hostsForZeroconfServiceEntry(entry *zeroconf.Service
 		ips = append(ips, entry.AddrIPv4...)
 	}
 
-	for idx, ip := range ips {
-		if m.addrsPerHost > 0 && idx >= m.addrsPerHost {
+	for _, ip := range ips {
+		// Only addresses that pass every filter count towards the limit.
+		if m.addrsPerHost > 0 && len(hosts) >= m.addrsPerHost {
 			break
 		}
 
@@ -162,8 +297,44 @@ func (m *MdnsForwardPlugin) hostsForZeroconfServiceEntry(entry *zeroconf.Service
 				entry.Instance, ip.String())
 			continue
 		}
+
+		if !m.addrAllowed(addr) {
+			log.Debugf("Ignoring address for entry '%s' because it is not allowed by the CIDR filters: %s",
+				entry.Instance, addr.String())
+			continue
+		}
+
+		// Link-local addresses can only be dialed through the interface they were learned on.
+		if addr.Is6() && addr.IsLinkLocalUnicast() {
+			zone := m.browser.Zone(entry.Instance, addr)
+			if zone == "" {
+				log.Debugf("Ignoring link-local address for entry '%s' because its interface is unknown: %s",
+					entry.Instance, addr.String())
+				continue
+			}
+			addr = addr.WithZone(zone)
+		}
 		hosts = append(hosts, netip.AddrPortFrom(addr, port))
 	}
 
 	return hosts
 }
+
+// addrAllowed applies the allow_cidr and deny_cidr filters. Deny wins over allow.
+func (m *MdnsForwardPlugin) addrAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range m.denyCidrs {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(m.allowCidrs) == 0 {
+		return true
+	}
+	for _, prefix := range m.allowCidrs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,13 @@
 package mdns
 
 import (
+	"context"
 	"net"
 	"net/netip"
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/grandcat/zeroconf"
 )
@@ -22,6 +24,8 @@ func TestHostFiltering(t *testing.T) {
 
 	entry := zeroconf.ServiceEntry{
 		ServiceRecord: zeroconf.ServiceRecord{Instance: "test_instance_name"},
+		HostName:      "test-host.local.",
+		Text:          []string{"role=prod", "flag"},
 		AddrIPv4: []net.IP{
 			netip.MustParseAddr("127.0.0.1").AsSlice(),
 			netip.MustParseAddr("2.2.2.2").AsSlice(),
@@ -83,6 +87,65 @@ func TestHostFiltering(t *testing.T) {
 				"127.0.0.1:10", "2.2.2.2:10", "3.3.3.3:10",
 			),
 		},
+		{
+			name:     "exclude",
+			plugin:   MdnsForwardPlugin{exclude: regexp.MustCompile("instance")},
+			expected: mustParseAddrPorts(),
+		},
+		{
+			name:   "exclude_no_match",
+			plugin: MdnsForwardPlugin{exclude: regexp.MustCompile("nothing"), addrMode: IPv4Only},
+			expected: mustParseAddrPorts(
+				"127.0.0.1:10", "2.2.2.2:10", "3.3.3.3:10",
+			),
+		},
+		{
+			name:     "hostname",
+			plugin:   MdnsForwardPlugin{hostnameFilter: regexp.MustCompile("^other-")},
+			expected: mustParseAddrPorts(),
+		},
+		{
+			name:   "hostname_match",
+			plugin: MdnsForwardPlugin{hostnameFilter: regexp.MustCompile("^test-"), addrMode: IPv4Only},
+			expected: mustParseAddrPorts(
+				"127.0.0.1:10", "2.2.2.2:10", "3.3.3.3:10",
+			),
+		},
+		{
+			name:   "require_txt",
+			plugin: MdnsForwardPlugin{requireTxt: map[string]string{"role": "prod", "flag": ""}, addrMode: IPv4Only},
+			expected: mustParseAddrPorts(
+				"127.0.0.1:10", "2.2.2.2:10", "3.3.3.3:10",
+			),
+		},
+		{
+			name:     "require_txt_mismatch",
+			plugin:   MdnsForwardPlugin{requireTxt: map[string]string{"role": "guest"}},
+			expected: mustParseAddrPorts(),
+		},
+		{
+			name:     "require_txt_missing",
+			plugin:   MdnsForwardPlugin{requireTxt: map[string]string{"other": ""}},
+			expected: mustParseAddrPorts(),
+		},
+		{
+			name:   "allow_cidr",
+			plugin: MdnsForwardPlugin{allowCidrs: []netip.Prefix{netip.MustParsePrefix("2.0.0.0/8")}, addrMode: PreferIPv4},
+			expected: mustParseAddrPorts(
+				"2.2.2.2:10",
+			),
+		},
+		{
+			name: "deny_cidr",
+			plugin: MdnsForwardPlugin{
+				allowCidrs: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")},
+				denyCidrs:  []netip.Prefix{netip.MustParsePrefix("2.2.2.0/24"), netip.MustParsePrefix("127.0.0.0/8")},
+				addrMode:   PreferIPv4,
+			},
+			expected: mustParseAddrPorts(
+				"3.3.3.3:10",
+			),
+		},
 		{
 			name:   "ignore_self",
 			plugin: MdnsForwardPlugin{ignoreSelf: true, addrMode: PreferIPv6},
@@ -105,6 +168,18 @@ func TestHostFiltering(t *testing.T) {
 				"127.0.0.1:10", "2.2.2.2:10",
 			),
 		},
+		{
+			name: "addrs_per_host_after_filters",
+			plugin: MdnsForwardPlugin{
+				addrsPerHost: DefaultAddrsPerHost,
+				allowCidrs:   []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")},
+				denyCidrs:    []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+				addrMode:     IPv4Only,
+			},
+			expected: mustParseAddrPorts(
+				"2.2.2.2:10",
+			),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -122,3 +197,54 @@ func TestHostFiltering(t *testing.T) {
 		})
 	}
 }
+
+func TestHostLinkLocalZones(t *testing.T) {
+	entry := zeroconf.ServiceEntry{
+		ServiceRecord: zeroconf.ServiceRecord{Instance: "test_instance_name"},
+		AddrIPv6: []net.IP{
+			net.ParseIP("fe80::1"),
+			net.ParseIP("fe80::2"),
+			net.ParseIP("2001:db8::1"),
+		},
+		Port: 10,
+	}
+
+	plugin := MdnsForwardPlugin{
+		addrMode: IPv6Only,
+		browser: &fakeBrowser{zones: map[netip.Addr]string{
+			netip.MustParseAddr("fe80::1"): "eth0",
+		}},
+	}
+
+	expected := mustParseAddrPorts("[fe80::1%eth0]:10", "[2001:db8::1]:10")
+	resultingHosts := plugin.hostsForZeroconfServiceEntry(&entry)
+	if !reflect.DeepEqual(expected, resultingHosts) {
+		t.Errorf("Resulting hosts do not match expected hosts.\nExpected: %v\nGot:      %v", expected, resultingHosts)
+	}
+
+	// An address of an unknown interface does not use up the limit.
+	entry.AddrIPv6 = entry.AddrIPv6[1:]
+	plugin.addrsPerHost = DefaultAddrsPerHost
+	expected = mustParseAddrPorts("[2001:db8::1]:10")
+	resultingHosts = plugin.hostsForZeroconfServiceEntry(&entry)
+	if !reflect.DeepEqual(expected, resultingHosts) {
+		t.Errorf("Resulting hosts do not match expected hosts.\nExpected: %v\nGot:      %v", expected, resultingHosts)
+	}
+}
+
+// fakeBrowser is a static browser.MdnsBrowserInterface for testing.
+type fakeBrowser struct {
+	services []*zeroconf.ServiceEntry
+	zones    map[netip.Addr]string
+	expiries map[string]time.Time
+}
+
+func (b *fakeBrowser) Start() error                       { return nil }
+func (b *fakeBrowser) Stop()                              {}
+func (b *fakeBrowser) Services() []*zeroconf.ServiceEntry { return b.services }
+func (b *fakeBrowser) PartitionServices(partition string) []*zeroconf.ServiceEntry {
+	return b.services
+}
+func (b *fakeBrowser) ForceRefresh(ctx context.Context)             {}
+func (b *fakeBrowser) Zone(instance string, addr netip.Addr) string { return b.zones[addr] }
+func (b *fakeBrowser) Expiry(instance string) time.Time             { return b.expiries[instance] }
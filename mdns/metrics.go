@@ -0,0 +1,19 @@
+package mdns
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// rateLimitedCount is the number of queries rejected by the rate limits,
+	// by the limit that was exceeded.
+	rateLimitedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dnsmesh_mdns_forward",
+		Name:      "rate_limited_requests_total",
+		Help:      "Counter of queries rejected by the mesh fanout rate limits.",
+	}, []string{"server", "limit"})
+)
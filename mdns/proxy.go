@@ -0,0 +1,319 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+
+	"github.com/nbeirne/coredns-dnsmesh/mdns/browser"
+)
+
+// serviceEnumeration is the name listing the browsed service types, see RFC
+// 6763 section 9.
+const serviceEnumeration = "_services._dns-sd._udp"
+
+var proxyLog = clog.NewWithPlugin(ProxyPluginName)
+
+// MdnsProxyPlugin is a discovery proxy as in RFC 8766. It answers unicast
+// queries for a zone with the services and hosts browsed in .local, so that
+// clients on other subnets or over a VPN can discover them, e.g. printers
+// browsed as _ipp._tcp.local. are listed under _ipp._tcp.home.example.
+type MdnsProxyPlugin struct {
+	Zone string         // the zone .local is published under
+	Next plugin.Handler // next plugin for queries outside the zone
+
+	ttl      uint32                                  // TTL of the published records
+	types    []string                                // browsed service types, e.g. _ipp._tcp
+	browsers map[string]browser.MdnsBrowserInterface // browser of each service type
+
+	// lookupHost looks up a host in .local which announces none of the
+	// browsed services, nil when hosts are not looked up.
+	lookupHost func(ctx context.Context, host string, entries chan<- *zeroconf.ServiceEntry) error
+}
+
+// Name implements the Handler interface.
+func (p *MdnsProxyPlugin) Name() string { return ProxyPluginName }
+
+// ServeDNS answers queries in the zone from the browsed services.
+func (p *MdnsProxyPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if plugin.Zones([]string{p.Zone}).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	}
+
+	answer := p.answer(ctx, state.Name(), state.QType())
+	m := new(dns.Msg).SetRcode(r, answer.Rcode)
+	m.Authoritative = true
+	m.Answer, m.Ns, m.Extra = answer.Answer, answer.Ns, answer.Extra
+	w.WriteMsg(m)
+	return dns.RcodeSuccess, nil
+}
+
+// answer looks up the records of a name in the zone. Names without records
+// of the type are answered with the SOA of the zone, as NXDOMAIN when
+// neither the name nor any name below it exists. Addresses of hosts which
+// announce none of the browsed services are looked up in .local.
+func (p *MdnsProxyPlugin) answer(ctx context.Context, qname string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	records := p.records()
+	qname = strings.ToLower(qname)
+	records = append(records, p.hostRecords(ctx, qname, records)...)
+
+	exists := qname == p.Zone
+	for _, rr := range records {
+		owner := strings.ToLower(rr.Header().Name)
+		if owner != qname {
+			exists = exists || dns.IsSubDomain(qname, owner)
+			continue
+		}
+		exists = true
+		if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	if qname == p.Zone && (qtype == dns.TypeSOA || qtype == dns.TypeANY) {
+		m.Answer = append(m.Answer, p.soa())
+	}
+
+	if len(m.Answer) == 0 {
+		m.Ns = []dns.RR{p.soa()}
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+		}
+		return m
+	}
+	m.Extra = additionals(m.Answer, records)
+	return m
+}
+
+// additionals returns the records a resolver needs to use an answer, see RFC
+// 6763 section 12: the SRV and TXT records of the instances a PTR answer
+// lists, and the addresses of the hosts an SRV record targets.
+func additionals(answer, records []dns.RR) []dns.RR {
+	instances := make(map[string]bool)
+	targets := make(map[string]bool)
+	answered := make(map[dns.RR]bool)
+	for _, rr := range answer {
+		answered[rr] = true
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			instances[strings.ToLower(rr.Ptr)] = true
+		case *dns.SRV:
+			targets[strings.ToLower(rr.Target)] = true
+		}
+	}
+	for _, rr := range records {
+		if srv, ok := rr.(*dns.SRV); ok && instances[strings.ToLower(srv.Hdr.Name)] {
+			targets[strings.ToLower(srv.Target)] = true
+		}
+	}
+
+	extra := []dns.RR{}
+	for _, rr := range records {
+		owner := strings.ToLower(rr.Header().Name)
+		switch rr.(type) {
+		case *dns.SRV, *dns.TXT:
+			if !instances[owner] {
+				continue
+			}
+		case *dns.A, *dns.AAAA:
+			if !targets[owner] {
+				continue
+			}
+		default:
+			continue
+		}
+		if !answered[rr] {
+			extra = append(extra, rr)
+		}
+	}
+	return extra
+}
+
+// records synthesises the records of the zone from the browsed services.
+func (p *MdnsProxyPlugin) records() []dns.RR {
+	records := []dns.RR{}
+	for _, serviceType := range p.types {
+		records = append(records, &dns.PTR{
+			Hdr: dns.RR_Header{Name: serviceEnumeration + "." + p.Zone, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: p.ttl},
+			Ptr: serviceType + "." + p.Zone,
+		})
+	}
+
+	for _, serviceType := range p.types {
+		services := p.browsers[serviceType].Services()
+		sort.Slice(services, func(i, j int) bool { return services[i].Instance < services[j].Instance })
+		for _, entry := range services {
+			records = append(records, p.serviceRecords(serviceType, entry)...)
+		}
+	}
+	return dns.Dedup(records, nil)
+}
+
+// serviceRecords translates a browsed instance into the zone: its PTR, SRV
+// and TXT records, and the addresses of its host. Link-local addresses are
+// left out, they are of no use to clients on other links. The records expire
+// no later than the instance does in the browser's cache.
+func (p *MdnsProxyPlugin) serviceRecords(serviceType string, entry *zeroconf.ServiceEntry) []dns.RR {
+	host, ok := p.hostName(entry.HostName)
+	if !ok {
+		return nil
+	}
+	service := serviceType + "." + p.Zone
+	// Browsed instance names are escaped already, as read from the wire.
+	instance := escapeLabel(unescapeLabel(entry.Instance)) + "." + service
+	ttl := min(p.ttl, entry.TTL)
+	if expiry := p.browsers[serviceType].Expiry(entry.Instance); !expiry.IsZero() {
+		ttl = min(ttl, uint32(max(time.Until(expiry)/time.Second, 0)))
+	}
+
+	txt := entry.Text
+	if len(txt) == 0 {
+		txt = []string{""} // RFC 6763 section 6.1
+	}
+	records := []dns.RR{
+		&dns.PTR{
+			Hdr: dns.RR_Header{Name: service, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+			Ptr: instance,
+		},
+		&dns.SRV{
+			Hdr:    dns.RR_Header{Name: instance, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+			Port:   uint16(entry.Port),
+			Target: host,
+		},
+		&dns.TXT{
+			Hdr: dns.RR_Header{Name: instance, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+			Txt: txt,
+		},
+	}
+	return append(records, addressRecords(host, entry, ttl)...)
+}
+
+// addressRecords returns the A and AAAA records of a host from its entry,
+// leaving out link-local IPv6 addresses.
+func addressRecords(host string, entry *zeroconf.ServiceEntry, ttl uint32) []dns.RR {
+	records := []dns.RR{}
+	for _, ip := range entry.AddrIPv4 {
+		records = append(records, &dns.A{
+			Hdr: dns.RR_Header{Name: host, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   ip,
+		})
+	}
+	for _, ip := range entry.AddrIPv6 {
+		if ip.IsLinkLocalUnicast() {
+			continue
+		}
+		records = append(records, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: host, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+			AAAA: ip,
+		})
+	}
+	return records
+}
+
+// hostRecords looks up a name of the zone which has no records as a host in
+// .local, e.g. "nas.home.example." as "nas.local.", and returns its
+// addresses. Only single labels below the zone are hosts, service names
+// start with an underscore. Hosts are looked up for any query type, so that
+// a host is not denied with NXDOMAIN when asked for e.g. its MX records.
+func (p *MdnsProxyPlugin) hostRecords(ctx context.Context, qname string, records []dns.RR) []dns.RR {
+	label, ok := strings.CutSuffix(qname, "."+p.Zone)
+	if p.lookupHost == nil || !ok || label == "" || strings.HasPrefix(label, "_") || dns.CountLabel(label+".") != 1 {
+		return nil
+	}
+	for _, rr := range records {
+		if owner := strings.ToLower(rr.Header().Name); owner == qname || dns.IsSubDomain(qname, owner) {
+			return nil
+		}
+	}
+
+	host := label + "." + DefaultDomain
+	ctx, cancel := context.WithTimeout(ctx, ProxyHostLookupTimeout)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	errs := make(chan error, 1)
+	go func() { errs <- p.lookupHost(ctx, host, entries) }()
+
+	select {
+	case entry := <-entries:
+		return addressRecords(qname, entry, min(p.ttl, entry.TTL))
+	case err := <-errs:
+		if err != nil {
+			proxyLog.Warningf("Failed to look up %s: %v", host, err)
+		}
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// hostName translates a host name in .local into the zone, e.g. "nas.local."
+// into "nas.home.example.".
+func (p *MdnsProxyPlugin) hostName(name string) (string, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	host, ok := strings.CutSuffix(name, "."+DefaultDomain)
+	if !ok || host == "" {
+		return "", false
+	}
+	return host + "." + p.Zone, true
+}
+
+// soa returns the SOA record of the zone. The records change with the
+// network, so the negative TTL is the TTL of the records.
+func (p *MdnsProxyPlugin) soa() dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: p.Zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: p.ttl},
+		Ns:      "ns.dns." + p.Zone,
+		Mbox:    "hostmaster." + p.Zone,
+		Serial:  1,
+		Refresh: 7200,
+		Retry:   1800,
+		Expire:  86400,
+		Minttl:  p.ttl,
+	}
+}
+
+// escapeLabel returns a string as a single DNS label in presentation format,
+// the reverse of unescapeLabel.
+func escapeLabel(label string) string {
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case strings.IndexByte(`. ()\;"@$`, c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Start starts browsing every service type.
+func (p *MdnsProxyPlugin) Start() error {
+	proxyLog.Infof("Starting discovery proxy for %s, browsing %s", p.Zone, strings.Join(p.types, ", "))
+	for _, serviceType := range p.types {
+		if err := p.browsers[serviceType].Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newProxyBrowser returns a browser for a service type in .local.
+func newProxyBrowser(serviceType string, ifaces *[]net.Interface) *browser.ZeroconfBrowser {
+	b := browser.NewZeroconfBrowser(DefaultDomain, serviceType, ifaces)
+	b.Log = proxyLog
+	return b
+}
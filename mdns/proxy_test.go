@@ -0,0 +1,250 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+
+	"github.com/nbeirne/coredns-dnsmesh/mdns/browser"
+)
+
+func newTestProxy() *MdnsProxyPlugin {
+	printer := &zeroconf.ServiceEntry{
+		ServiceRecord: zeroconf.ServiceRecord{Instance: `Office\ Printer`, Service: "_ipp._tcp", Domain: "local."},
+		HostName:      "printer.local.",
+		Port:          631,
+		Text:          []string{"rp=ipp/print"},
+		TTL:           120,
+		AddrIPv4:      []net.IP{net.ParseIP("192.168.1.20")},
+		AddrIPv6:      []net.IP{net.ParseIP("fe80::20"), net.ParseIP("fd00::20")},
+	}
+	speaker := &zeroconf.ServiceEntry{
+		ServiceRecord: zeroconf.ServiceRecord{Instance: "Kitchen", Service: "_airplay._tcp", Domain: "local."},
+		HostName:      "speaker.local.",
+		Port:          7000,
+		TTL:           120,
+		AddrIPv4:      []net.IP{net.ParseIP("192.168.1.30")},
+	}
+	return &MdnsProxyPlugin{
+		Zone:  "home.example.",
+		ttl:   DefaultProxyTTL,
+		types: []string{"_ipp._tcp", "_airplay._tcp"},
+		browsers: map[string]browser.MdnsBrowserInterface{
+			"_ipp._tcp": &fakeBrowser{services: []*zeroconf.ServiceEntry{printer}},
+			"_airplay._tcp": &fakeBrowser{
+				services: []*zeroconf.ServiceEntry{speaker},
+				// Expires sooner than its TTL, e.g. when a refresh failed.
+				expiries: map[string]time.Time{"Kitchen": time.Now().Add(4500 * time.Millisecond)},
+			},
+		},
+	}
+}
+
+func TestProxyServeDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		qname         string
+		qtype         uint16
+		expectedRcode int
+		expected      []string // answer records
+		expectedExtra []string
+	}{
+		{
+			name:  "service types",
+			qname: "_services._dns-sd._udp.home.example.",
+			qtype: dns.TypePTR,
+			expected: []string{
+				"_services._dns-sd._udp.home.example.\t10\tIN\tPTR\t_airplay._tcp.home.example.",
+				"_services._dns-sd._udp.home.example.\t10\tIN\tPTR\t_ipp._tcp.home.example.",
+			},
+		},
+		{
+			name:     "browse",
+			qname:    "_ipp._tcp.home.example.",
+			qtype:    dns.TypePTR,
+			expected: []string{"_ipp._tcp.home.example.\t10\tIN\tPTR\tOffice\\ Printer._ipp._tcp.home.example."},
+			expectedExtra: []string{
+				"Office\\ Printer._ipp._tcp.home.example.\t10\tIN\tSRV\t0 0 631 printer.home.example.",
+				"Office\\ Printer._ipp._tcp.home.example.\t10\tIN\tTXT\t\"rp=ipp/print\"",
+				"printer.home.example.\t10\tIN\tA\t192.168.1.20",
+				"printer.home.example.\t10\tIN\tAAAA\tfd00::20",
+			},
+		},
+		{
+			name:     "resolve",
+			qname:    "office\\ printer._ipp._tcp.HOME.example.",
+			qtype:    dns.TypeSRV,
+			expected: []string{"Office\\ Printer._ipp._tcp.home.example.\t10\tIN\tSRV\t0 0 631 printer.home.example."},
+			expectedExtra: []string{
+				"printer.home.example.\t10\tIN\tA\t192.168.1.20",
+				"printer.home.example.\t10\tIN\tAAAA\tfd00::20",
+			},
+		},
+		{
+			name:     "empty txt",
+			qname:    "Kitchen._airplay._tcp.home.example.",
+			qtype:    dns.TypeTXT,
+			expected: []string{"Kitchen._airplay._tcp.home.example.\t4\tIN\tTXT\t\"\""},
+		},
+		{
+			name:     "host",
+			qname:    "speaker.home.example.",
+			qtype:    dns.TypeA,
+			expected: []string{"speaker.home.example.\t4\tIN\tA\t192.168.1.30"},
+		},
+		{name: "no data", qname: "speaker.home.example.", qtype: dns.TypeAAAA},
+		{name: "empty non-terminal", qname: "_tcp.home.example.", qtype: dns.TypePTR},
+		{name: "unknown instance", qname: "Bedroom._airplay._tcp.home.example.", qtype: dns.TypeSRV, expectedRcode: dns.RcodeNameError},
+		{name: "unbrowsed type", qname: "_http._tcp.home.example.", qtype: dns.TypePTR, expectedRcode: dns.RcodeNameError},
+		{name: "apex", qname: "home.example.", qtype: dns.TypeA},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProxy()
+			req := new(dns.Msg).SetQuestion(tc.qname, tc.qtype)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := p.ServeDNS(context.Background(), rec, req); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+
+			resp := rec.Msg
+			if resp.Rcode != tc.expectedRcode {
+				t.Errorf("Unexpected rcode: got %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tc.expectedRcode])
+			}
+			if !resp.Authoritative {
+				t.Error("Expected an authoritative answer")
+			}
+			assertRecords(t, "answer", resp.Answer, tc.expected)
+			assertRecords(t, "additional", resp.Extra, tc.expectedExtra)
+			if len(resp.Answer) == 0 && (len(resp.Ns) != 1 || resp.Ns[0].Header().Rrtype != dns.TypeSOA) {
+				t.Errorf("Expected the SOA in a negative answer, got %v", resp.Ns)
+			}
+		})
+	}
+}
+
+func TestProxyHostLookup(t *testing.T) {
+	nas := &zeroconf.ServiceEntry{
+		HostName: "nas.local.",
+		TTL:      120,
+		AddrIPv4: []net.IP{net.ParseIP("192.168.1.10")},
+		AddrIPv6: []net.IP{net.ParseIP("fe80::10"), net.ParseIP("fd00::10")},
+	}
+	testCases := []struct {
+		name          string
+		qname         string
+		qtype         uint16
+		expectedRcode int
+		expected      []string
+		expectedHosts []string // hosts looked up
+	}{
+		{
+			name:          "other host",
+			qname:         "NAS.home.example.",
+			qtype:         dns.TypeA,
+			expected:      []string{"nas.home.example.\t10\tIN\tA\t192.168.1.10"},
+			expectedHosts: []string{"nas.local."},
+		},
+		{
+			name:          "other host any",
+			qname:         "nas.home.example.",
+			qtype:         dns.TypeANY,
+			expected:      []string{"nas.home.example.\t10\tIN\tA\t192.168.1.10", "nas.home.example.\t10\tIN\tAAAA\tfd00::10"},
+			expectedHosts: []string{"nas.local."},
+		},
+		{name: "other host no data", qname: "nas.home.example.", qtype: dns.TypeMX, expectedHosts: []string{"nas.local."}},
+		{name: "unknown host", qname: "tv.home.example.", qtype: dns.TypeAAAA, expectedRcode: dns.RcodeNameError, expectedHosts: []string{"tv.local."}},
+		{name: "browsed host", qname: "printer.home.example.", qtype: dns.TypeA, expected: []string{"printer.home.example.\t10\tIN\tA\t192.168.1.20"}},
+		{name: "service name", qname: "_http._tcp.home.example.", qtype: dns.TypeA, expectedRcode: dns.RcodeNameError},
+		{name: "below a host", qname: "www.nas.home.example.", qtype: dns.TypeA, expectedRcode: dns.RcodeNameError},
+		{name: "empty non-terminal", qname: "_tcp.home.example.", qtype: dns.TypeA},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProxy()
+			hosts := []string{}
+			p.lookupHost = func(ctx context.Context, host string, entries chan<- *zeroconf.ServiceEntry) error {
+				hosts = append(hosts, host)
+				if host == nas.HostName {
+					entries <- nas
+				}
+				return nil
+			}
+			req := new(dns.Msg).SetQuestion(tc.qname, tc.qtype)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := p.ServeDNS(context.Background(), rec, req); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+
+			if rec.Msg.Rcode != tc.expectedRcode {
+				t.Errorf("Unexpected rcode: got %s, want %s", dns.RcodeToString[rec.Msg.Rcode], dns.RcodeToString[tc.expectedRcode])
+			}
+			assertRecords(t, "answer", rec.Msg.Answer, tc.expected)
+			if len(hosts) != len(tc.expectedHosts) || (len(hosts) > 0 && hosts[0] != tc.expectedHosts[0]) {
+				t.Errorf("Unexpected lookups: got %v, want %v", hosts, tc.expectedHosts)
+			}
+		})
+	}
+}
+
+func TestProxyServeDNSOutsideZone(t *testing.T) {
+	p := newTestProxy()
+	next := &staticFanout{}
+	p.Next = test.HandlerFunc(next.ServeDNS)
+
+	req := new(dns.Msg).SetQuestion("printer.local.", dns.TypeA)
+	if _, err := p.ServeDNS(context.Background(), dnstest.NewRecorder(&test.ResponseWriter{}), req); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if next.calls != 1 {
+		t.Errorf("Expected the query to be passed on, got %d calls", next.calls)
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	testCases := map[string]string{
+		"plain":          `plain`,
+		"Office Printer": `Office\ Printer`,
+		"dot.ted (2)":    `dot\.ted\ \(2\)`,
+		"caf\xc3\xa9":    `caf\195\169`,
+		`back\slash;"@$`: `back\\slash\;\"\@\$`,
+	}
+	for label, expected := range testCases {
+		escaped := escapeLabel(label)
+		if escaped != expected {
+			t.Errorf("escapeLabel(%q): expected %q, got %q", label, expected, escaped)
+		}
+		if unescaped := unescapeLabel(escaped); unescaped != label {
+			t.Errorf("unescapeLabel(%q): expected %q, got %q", escaped, label, unescaped)
+		}
+	}
+}
+
+func assertRecords(t *testing.T, section string, rrs []dns.RR, expected []string) {
+	t.Helper()
+	got := []string{}
+	for _, rr := range rrs {
+		got = append(got, rr.String())
+	}
+	sort.Strings(got)
+	sort.Strings(expected)
+	if len(got) != len(expected) {
+		t.Errorf("Unexpected %s section:\ngot  %q\nwant %q", section, got, expected)
+		return
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("Unexpected %s section:\ngot  %q\nwant %q", section, got, expected)
+			return
+		}
+	}
+}
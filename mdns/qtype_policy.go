@@ -0,0 +1,70 @@
+package mdns
+
+import (
+	"math/rand"
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+// Fanout policies which can be chosen per query type.
+const (
+	SequentialPolicy int = 0 // ask all peers, the first successful response wins
+	RacePolicy           = 1 // ask all peers, the first response wins even if it is not successful
+	SinglePeerPolicy     = 2 // ask a single, randomly chosen peer
+)
+
+var policyNames = map[string]int{
+	"sequential": SequentialPolicy,
+	"race":       RacePolicy,
+	"single":     SinglePeerPolicy,
+}
+
+// policyFor returns the fanout policy for a query type.
+func (m *MdnsForwardPlugin) policyFor(qtype uint16) int {
+	if policy, ok := m.qtypePolicies[qtype]; ok {
+		return policy
+	}
+	return m.policy
+}
+
+// qtypeAllowed applies the allow_qtypes and deny_qtypes options. Deny wins over allow.
+func (m *MdnsForwardPlugin) qtypeAllowed(qtype uint16) bool {
+	if m.denyQtypes[qtype] {
+		return false
+	}
+	return m.allowQtypes == nil || m.allowQtypes[qtype]
+}
+
+// applyPolicy limits the peers to ask according to the policy.
+func applyPolicy(policy int, clients []string) []string {
+	if policy == SinglePeerPolicy && len(clients) > 1 {
+		return []string{clients[rand.Intn(len(clients))]}
+	}
+	return clients
+}
+
+// writeMinimalAny answers an ANY query with a single synthesized HINFO record as described in RFC 8482.
+func writeMinimalAny(w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	resp := new(dns.Msg).SetReply(r)
+	resp.Answer = []dns.RR{&dns.HINFO{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: 8482},
+		Cpu: "RFC8482",
+	}}
+	w.WriteMsg(resp)
+	return dns.RcodeSuccess, nil
+}
+
+// parseQtypes parses the query type arguments of the current option.
+func parseQtypes(c *caddy.Controller, args []string) (map[uint16]bool, error) {
+	qtypes := make(map[uint16]bool)
+	for _, arg := range args {
+		qtype, ok := dns.StringToType[strings.ToUpper(arg)]
+		if !ok {
+			return nil, c.Errf("unknown query type: %s", arg)
+		}
+		qtypes[qtype] = true
+	}
+	return qtypes, nil
+}
@@ -0,0 +1,128 @@
+package mdns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// staticFanout answers every query with a successful response.
+type staticFanout struct {
+	calls int
+}
+
+func (f *staticFanout) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	f.calls++
+	resp := new(dns.Msg).SetReply(r)
+	w.WriteMsg(resp)
+	return dns.RcodeSuccess, nil
+}
+
+func TestQtypePolicyServeDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		plugin        MdnsForwardPlugin
+		qname         string
+		qtype         uint16
+		expectedRcode int
+		expectedCalls int
+		expectHinfo   bool
+	}{
+		{
+			name:          "allowed by default",
+			qname:         "host.example.com.",
+			qtype:         dns.TypeA,
+			expectedCalls: 1,
+		},
+		{
+			name:          "denied qtype",
+			plugin:        MdnsForwardPlugin{denyQtypes: map[uint16]bool{dns.TypeAXFR: true}},
+			qname:         "example.com.",
+			qtype:         dns.TypeAXFR,
+			expectedRcode: dns.RcodeRefused,
+		},
+		{
+			name:          "not in allowed qtypes",
+			plugin:        MdnsForwardPlugin{allowQtypes: map[uint16]bool{dns.TypeA: true}},
+			qname:         "host.example.com.",
+			qtype:         dns.TypeTXT,
+			expectedRcode: dns.RcodeRefused,
+		},
+		{
+			name: "deny wins over allow",
+			plugin: MdnsForwardPlugin{
+				allowQtypes: map[uint16]bool{dns.TypePTR: true},
+				denyQtypes:  map[uint16]bool{dns.TypePTR: true},
+			},
+			qname:         "host.example.com.",
+			qtype:         dns.TypePTR,
+			expectedRcode: dns.RcodeRefused,
+		},
+		{
+			name:          "denied qtype outside of zone",
+			plugin:        MdnsForwardPlugin{denyQtypes: map[uint16]bool{dns.TypeA: true}},
+			qname:         "host.example.org.",
+			qtype:         dns.TypeA,
+			expectedCalls: 1,
+		},
+		{
+			name:        "minimal any",
+			plugin:      MdnsForwardPlugin{minimalAny: true},
+			qname:       "host.example.com.",
+			qtype:       dns.TypeANY,
+			expectHinfo: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &staticFanout{}
+			tc.plugin.Zone = "example.com."
+			tc.plugin.createFanoutFunc = func(p *MdnsForwardPlugin) fanoutHandler { return f }
+
+			req := new(dns.Msg).SetQuestion(tc.qname, tc.qtype)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			rcode, err := tc.plugin.ServeDNS(context.Background(), rec, req)
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+
+			if rcode != tc.expectedRcode {
+				t.Errorf("Unexpected rcode: got %d, want %d", rcode, tc.expectedRcode)
+			}
+			if f.calls != tc.expectedCalls {
+				t.Errorf("Unexpected fanout calls: got %d, want %d", f.calls, tc.expectedCalls)
+			}
+			if tc.expectHinfo {
+				if rec.Msg == nil || len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].Header().Rrtype != dns.TypeHINFO {
+					t.Errorf("Expected a single HINFO answer, got: %v", rec.Msg)
+				}
+			}
+		})
+	}
+}
+
+func TestQtypePolicySelection(t *testing.T) {
+	m := MdnsForwardPlugin{
+		policy:        RacePolicy,
+		qtypePolicies: map[uint16]int{dns.TypePTR: SinglePeerPolicy},
+	}
+
+	if policy := m.policyFor(dns.TypeA); policy != RacePolicy {
+		t.Errorf("Unexpected policy for A: got %d, want %d", policy, RacePolicy)
+	}
+	if policy := m.policyFor(dns.TypePTR); policy != SinglePeerPolicy {
+		t.Errorf("Unexpected policy for PTR: got %d, want %d", policy, SinglePeerPolicy)
+	}
+
+	clients := []string{"10.0.0.1:53", "10.0.0.2:53", "10.0.0.3:53"}
+	if selected := applyPolicy(SinglePeerPolicy, clients); len(selected) != 1 {
+		t.Errorf("Unexpected peer count for single policy: got %d, want 1", len(selected))
+	}
+	if selected := applyPolicy(SequentialPolicy, clients); len(selected) != len(clients) {
+		t.Errorf("Unexpected peer count for sequential policy: got %d, want %d", len(selected), len(clients))
+	}
+}
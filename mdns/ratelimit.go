@@ -0,0 +1,110 @@
+package mdns
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate limit actions for queries over the limit.
+const (
+	RefuseAction      int = 0 // answer with REFUSED
+	FallthroughAction     = 1 // pass the query to the next plugin
+)
+
+// Default prefix lengths clients are grouped by for the per client limit.
+const (
+	DefaultRateLimitIPv4Bits = 32
+	DefaultRateLimitIPv6Bits = 128
+)
+
+// rateLimitIdle is how long a client bucket is kept after its last query.
+const rateLimitIdle = 5 * time.Minute
+
+// rateLimiter limits the queries fanned out to the mesh with token buckets per
+// client prefix and a global token bucket shared by all clients.
+type rateLimiter struct {
+	clientRate  rate.Limit // 0 disables the per client limit
+	clientBurst int
+	ipv4Bits    int
+	ipv6Bits    int
+	global      *rate.Limiter // nil disables the global limit
+	action      int
+
+	mutex     sync.Mutex
+	clients   map[netip.Prefix]*clientBucket
+	lastPurge time.Time
+
+	now func() time.Time
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		ipv4Bits: DefaultRateLimitIPv4Bits,
+		ipv6Bits: DefaultRateLimitIPv6Bits,
+		clients:  make(map[netip.Prefix]*clientBucket),
+		now:      time.Now,
+	}
+}
+
+// allow takes a token for a query from the client. It returns "" when the
+// query may be fanned out, or the name of the exceeded limit. A refused query
+// takes no token from either limit, so that clients which are refused by the
+// global limit keep their own tokens.
+func (l *rateLimiter) allow(client netip.Addr) string {
+	now := l.now()
+	var clientToken *rate.Reservation
+	if l.clientRate > 0 && client.IsValid() {
+		clientToken = l.clientBucket(client, now).ReserveN(now, 1)
+		if clientToken.DelayFrom(now) > 0 {
+			clientToken.CancelAt(now)
+			return "client"
+		}
+	}
+	if l.global != nil {
+		if globalToken := l.global.ReserveN(now, 1); globalToken.DelayFrom(now) > 0 {
+			globalToken.CancelAt(now)
+			if clientToken != nil {
+				clientToken.CancelAt(now)
+			}
+			return "global"
+		}
+	}
+	return ""
+}
+
+func (l *rateLimiter) clientBucket(client netip.Addr, now time.Time) *rate.Limiter {
+	client = client.Unmap()
+	bits := l.ipv6Bits
+	if client.Is4() {
+		bits = l.ipv4Bits
+	}
+	prefix, _ := client.WithZone("").Prefix(bits)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastPurge) > rateLimitIdle {
+		for key, bucket := range l.clients {
+			if now.Sub(bucket.lastSeen) > rateLimitIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastPurge = now
+	}
+
+	bucket, ok := l.clients[prefix]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(l.clientRate, l.clientBurst)}
+		l.clients[prefix] = bucket
+	}
+	bucket.lastSeen = now
+	return bucket.limiter
+}
@@ -0,0 +1,135 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter()
+	l.clientRate = 1
+	l.clientBurst = 2
+	l.ipv4Bits = 24
+	l.now = func() time.Time { return now }
+
+	client := netip.MustParseAddr("192.168.1.10")
+	neighbour := netip.MustParseAddr("192.168.1.20")
+	other := netip.MustParseAddr("192.168.2.10")
+
+	if limit := l.allow(client); limit != "" {
+		t.Fatalf("Expected the first query to be allowed, got %s", limit)
+	}
+	if limit := l.allow(neighbour); limit != "" {
+		t.Fatalf("Expected the burst to allow a second query, got %s", limit)
+	}
+	if limit := l.allow(client); limit != "client" {
+		t.Fatalf("Expected the prefix to be limited, got %q", limit)
+	}
+	if limit := l.allow(other); limit != "" {
+		t.Fatalf("Expected another prefix to be allowed, got %s", limit)
+	}
+
+	now = now.Add(time.Second)
+	if limit := l.allow(client); limit != "" {
+		t.Fatalf("Expected a refilled token to be allowed, got %s", limit)
+	}
+
+	// Idle buckets are purged.
+	now = now.Add(2 * rateLimitIdle)
+	l.allow(other)
+	if len(l.clients) != 1 {
+		t.Errorf("Expected idle buckets to be purged, got %d buckets", len(l.clients))
+	}
+
+	l.global = rate.NewLimiter(1, 1)
+	if limit := l.allow(netip.MustParseAddr("10.0.0.1")); limit != "" {
+		t.Fatalf("Expected the global limit to allow a query, got %s", limit)
+	}
+	if limit := l.allow(netip.MustParseAddr("10.0.1.1")); limit != "global" {
+		t.Fatalf("Expected the global limit to be exceeded, got %q", limit)
+	}
+}
+
+func TestRateLimiterRefusedKeepTokens(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter()
+	l.clientRate = rate.Every(time.Hour)
+	l.clientBurst = 1
+	l.global = rate.NewLimiter(1, 1)
+	l.now = func() time.Time { return now }
+
+	client := netip.MustParseAddr("192.168.1.10")
+	other := netip.MustParseAddr("192.168.2.10")
+
+	if limit := l.allow(other); limit != "" {
+		t.Fatalf("Expected the first query to be allowed, got %s", limit)
+	}
+	if limit := l.allow(other); limit != "client" {
+		t.Fatalf("Expected the client limit to be exceeded, got %q", limit)
+	}
+	if limit := l.allow(client); limit != "global" {
+		t.Fatalf("Expected the global limit to be exceeded, got %q", limit)
+	}
+
+	// Neither refused query took a token: the global token refilled in a
+	// second goes to the client refused by the global limit.
+	now = now.Add(time.Second)
+	if limit := l.allow(client); limit != "" {
+		t.Fatalf("Expected the client to have kept its token, got %s", limit)
+	}
+}
+
+func TestRateLimitServeDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		action        int
+		qname         string
+		expectedRcode int
+		expectedCalls int
+	}{
+		{name: "refuse", action: RefuseAction, qname: "host.example.com.", expectedRcode: dns.RcodeRefused, expectedCalls: 1},
+		{name: "fallthrough", action: FallthroughAction, qname: "host.example.com.", expectedRcode: dns.RcodeSuccess, expectedCalls: 1},
+		{name: "outside of zone", action: RefuseAction, qname: "host.example.org.", expectedRcode: dns.RcodeSuccess, expectedCalls: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &staticFanout{}
+			limiter := newRateLimiter()
+			limiter.clientRate = 1
+			limiter.clientBurst = 1
+			limiter.action = tc.action
+			m := MdnsForwardPlugin{
+				Zone:             "example.com.",
+				Next:             test.NextHandler(dns.RcodeSuccess, nil),
+				rateLimiter:      limiter,
+				createFanoutFunc: func(p *MdnsForwardPlugin) fanoutHandler { return f },
+			}
+
+			w := &test.ResponseWriter{RemoteIP: net.ParseIP("10.0.0.1").String()}
+			req := new(dns.Msg).SetQuestion(tc.qname, dns.TypeA)
+			if _, err := m.ServeDNS(context.Background(), dnstest.NewRecorder(w), req); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			rcode, err := m.ServeDNS(context.Background(), dnstest.NewRecorder(w), req)
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if rcode != tc.expectedRcode {
+				t.Errorf("Unexpected rcode: got %d, want %d", rcode, tc.expectedRcode)
+			}
+			if f.calls != tc.expectedCalls {
+				t.Errorf("Unexpected fanout calls: got %d, want %d", f.calls, tc.expectedCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package mdns
+
+import (
+	"sync"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/nbeirne/coredns-dnsmesh/mdns/browser"
+)
+
+// registry keeps browsers and advertisers alive across CoreDNS reloads. On a
+// reload the new configuration is set up and started before the old one is
+// shut down, so an entry acquired with the same key by both configurations
+// survives the reload with its state intact. Entries are stopped once their
+// last reference is released.
+type registry[T any] struct {
+	mutex   sync.Mutex
+	entries map[string]*registryEntry[T]
+	stop    func(T)
+}
+
+type registryEntry[T any] struct {
+	value T
+	refs  int
+}
+
+func newRegistry[T any](stop func(T)) *registry[T] {
+	return &registry[T]{
+		entries: make(map[string]*registryEntry[T]),
+		stop:    stop,
+	}
+}
+
+var (
+	browsers    = newRegistry(func(b browser.MdnsBrowserInterface) { b.Stop() })
+	advertisers = newRegistry(func(a *MdnsAdvertise) { a.StopAdvertise() })
+)
+
+// pendingReleases are the releases of the configuration being set up, until
+// it has started. Caddy discards a configuration which fails to start on a
+// reload without running its shutdown callbacks, and runs the restart-failed
+// callbacks of the previous configuration instead.
+var (
+	pendingMutex    sync.Mutex
+	pendingReleases []func()
+	pendingOnce     sync.Once
+)
+
+// onRelease runs release when the configuration being set up shuts down, or
+// when it fails to start on a reload.
+func onRelease(c *caddy.Controller, release func()) {
+	pendingMutex.Lock()
+	pendingReleases = append(pendingReleases, release)
+	pendingMutex.Unlock()
+
+	pendingOnce.Do(func() {
+		caddy.RegisterEventHook("dnsmesh_mdns_registry", commitReleases)
+	})
+	c.OnShutdown(func() error {
+		release()
+		return nil
+	})
+	c.OnRestartFailed(func() error {
+		releasePending()
+		return nil
+	})
+}
+
+// commitReleases leaves the releases of a configuration that started to its
+// shutdown callbacks.
+func commitReleases(event caddy.EventName, _ interface{}) error {
+	if event == caddy.InstanceStartupEvent {
+		pendingMutex.Lock()
+		pendingReleases = nil
+		pendingMutex.Unlock()
+	}
+	return nil
+}
+
+// releasePending releases the entries acquired by a configuration which
+// failed to start. It runs as a restart-failed callback of the previous
+// configuration, which registers one for each of its own entries, so all
+// but the first call find nothing left to release.
+func releasePending() {
+	pendingMutex.Lock()
+	releases := pendingReleases
+	pendingReleases = nil
+	pendingMutex.Unlock()
+
+	for _, release := range releases {
+		release()
+	}
+}
+
+// acquire returns the entry registered for key, or registers value if there
+// is none. Every acquire must be paired with a release, see onRelease.
+func (r *registry[T]) acquire(key string, value T) T {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, ok := r.entries[key]
+	if !ok {
+		entry = &registryEntry[T]{value: value}
+		r.entries[key] = entry
+	}
+	entry.refs++
+	return entry.value
+}
+
+// values returns the registered entries.
+func (r *registry[T]) values() []T {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	values := make([]T, 0, len(r.entries))
+	for _, entry := range r.entries {
+		values = append(values, entry.value)
+	}
+	return values
+}
+
+// release drops a reference to the entry registered for key and stops it
+// when no references are left.
+func (r *registry[T]) release(key string) {
+	r.mutex.Lock()
+	entry, ok := r.entries[key]
+	if !ok {
+		r.mutex.Unlock()
+		return
+	}
+	entry.refs--
+	if entry.refs > 0 {
+		r.mutex.Unlock()
+		return
+	}
+	delete(r.entries, key)
+	r.mutex.Unlock()
+
+	r.stop(entry.value)
+}
+
+// serverAdvertisers maps the config of each server block to its advertiser,
+// so that other plugins can publish runtime state in its TXT record.
+var (
+	serverAdvertisersMutex sync.RWMutex
+	serverAdvertisers      = make(map[*dnsserver.Config]*MdnsAdvertise)
+)
+
+// AdvertiserFor returns the advertiser of the server block config belongs to,
+// or nil if the block does not use the dnsmesh_mdns_advertise directive.
+// Plugins should look it up on startup, as setup order follows the directive
+// order in plugin.cfg:
+//
+//	c.OnStartup(func() error {
+//		if a := mdns.AdvertiserFor(dnsserver.GetConfig(c)); a != nil {
+//			return a.SetTxt("serial", serial)
+//		}
+//		return nil
+//	})
+func AdvertiserFor(config *dnsserver.Config) *MdnsAdvertise {
+	serverAdvertisersMutex.RLock()
+	defer serverAdvertisersMutex.RUnlock()
+	return serverAdvertisers[config]
+}
+
+func registerServerAdvertiser(config *dnsserver.Config, advertiser *MdnsAdvertise) {
+	serverAdvertisersMutex.Lock()
+	defer serverAdvertisersMutex.Unlock()
+	serverAdvertisers[config] = advertiser
+}
+
+func unregisterServerAdvertiser(config *dnsserver.Config) {
+	serverAdvertisersMutex.Lock()
+	defer serverAdvertisersMutex.Unlock()
+	delete(serverAdvertisers, config)
+}
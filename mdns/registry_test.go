@@ -0,0 +1,396 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/grandcat/zeroconf"
+)
+
+type countingStopper struct {
+	stops int
+}
+
+func TestRegistryRefCounting(t *testing.T) {
+	r := newRegistry(func(s *countingStopper) { s.stops++ })
+
+	first := &countingStopper{}
+	second := &countingStopper{}
+
+	if got := r.acquire("a", first); got != first {
+		t.Fatal("Expected the first value to be registered")
+	}
+	// A reload acquires the same key before the old configuration releases it.
+	if got := r.acquire("a", second); got != first {
+		t.Fatal("Expected the registered value to be reused")
+	}
+
+	r.release("a")
+	if first.stops != 0 {
+		t.Fatalf("Expected no stop while a reference is left, got %d", first.stops)
+	}
+
+	r.release("a")
+	if first.stops != 1 {
+		t.Fatalf("Expected a stop after the last release, got %d", first.stops)
+	}
+	if second.stops != 0 {
+		t.Fatalf("Expected the unused value to never be stopped, got %d", second.stops)
+	}
+
+	// Releasing an unknown key is a no-op.
+	r.release("a")
+	if first.stops != 1 {
+		t.Fatalf("Expected a single stop, got %d", first.stops)
+	}
+
+	// Once stopped, the key registers a new value.
+	if got := r.acquire("a", second); got != second {
+		t.Fatal("Expected a new value after the last release")
+	}
+}
+
+func TestSetupReusesStateAcrossReloads(t *testing.T) {
+	forward := `dnsmesh_mdns_forward example.com {
+		type _reload._udp
+	}`
+	advertise := `dnsmesh_mdns_advertise {
+		type _reload._udp
+		port 1053
+	}`
+
+	setup := func() {
+		c := caddy.NewTestController("dns", forward)
+		if err := setupForward(c); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+		c = caddy.NewTestController("dns", advertise)
+		if err := setupAdvertise(c); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	browsersBefore := keysOf(browsers)
+	advertisersBefore := keysOf(advertisers)
+	setup()
+	browserKeys := newKeys(browsers, browsersBefore)
+	advertiserKeys := newKeys(advertisers, advertisersBefore)
+	if len(browserKeys) != 1 || len(advertiserKeys) != 1 {
+		t.Fatalf("Expected one browser and one advertiser, got %v and %v", browserKeys, advertiserKeys)
+	}
+
+	// The reloaded configuration is set up before the old one shuts down.
+	setup()
+	if browsers.entries[browserKeys[0]].refs != 2 {
+		t.Errorf("Expected the browser to be shared by both configurations")
+	}
+	if advertisers.entries[advertiserKeys[0]].refs != 2 {
+		t.Errorf("Expected the advertiser to be shared by both configurations")
+	}
+
+	browsers.release(browserKeys[0])
+	advertisers.release(advertiserKeys[0])
+	if browsers.entries[browserKeys[0]] == nil || advertisers.entries[advertiserKeys[0]] == nil {
+		t.Fatal("Expected the entries to survive the shutdown of the old configuration")
+	}
+
+	browsers.release(browserKeys[0])
+	advertisers.release(advertiserKeys[0])
+	if browsers.entries[browserKeys[0]] != nil || advertisers.entries[advertiserKeys[0]] != nil {
+		t.Error("Expected the entries to be removed after the last release")
+	}
+}
+
+func TestSetupReleasesOnFailedReload(t *testing.T) {
+	forward := `dnsmesh_mdns_forward example.com {
+		type _failed._udp
+	}`
+	changed := `dnsmesh_mdns_forward example.com {
+		type _changed._udp
+	}`
+	setup := func(input string) {
+		c := caddy.NewTestController("dns", input)
+		if err := setupForward(c); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	// The configurations of other tests count as running.
+	commitReleases(caddy.InstanceStartupEvent, nil)
+	before := keysOf(browsers)
+	setup(forward)
+	commitReleases(caddy.InstanceStartupEvent, nil)
+	keys := newKeys(browsers, before)
+	if len(keys) != 1 {
+		t.Fatalf("Expected one browser, got %v", keys)
+	}
+
+	// The reloaded configuration is set up, but fails to start.
+	before = keysOf(browsers)
+	advertisersBefore := keysOf(advertisers)
+	setup(forward)
+	setup(changed)
+	advertise := caddy.NewTestController("dns", `dnsmesh_mdns_advertise {
+		type _failed._udp
+		port 1053
+	}`)
+	if err := setupAdvertise(advertise); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	changedKeys := newKeys(browsers, before)
+	if len(changedKeys) != 1 || browsers.entries[keys[0]].refs != 2 {
+		t.Fatalf("Expected the browser to be shared and one new browser, got %v", changedKeys)
+	}
+	proxy := caddy.NewTestController("dns", `dnsmesh_mdns_proxy home.example {
+		type _proxyfailed._tcp
+	}`)
+	if err := setupProxy(proxy); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	proxyKeys := newKeys(browsers, before)
+	if len(proxyKeys) != 2 {
+		t.Fatalf("Expected a new browser for the proxy, got %v", proxyKeys)
+	}
+	// Caddy runs the restart-failed callbacks of the running configuration.
+	releasePending()
+	releasePending()
+
+	if browsers.entries[keys[0]] == nil || browsers.entries[keys[0]].refs != 1 {
+		t.Error("Expected the running configuration to keep its browser")
+	}
+	if keys := newKeys(browsers, before); len(keys) != 0 {
+		t.Errorf("Expected the browsers of the failed configuration to be stopped, got %v", keys)
+	}
+	if keys := newKeys(advertisers, advertisersBefore); len(keys) != 0 {
+		t.Errorf("Expected the advertiser of the failed configuration to be stopped, got %v", keys)
+	}
+	if AdvertiserFor(dnsserver.GetConfig(advertise)) != nil {
+		t.Error("Expected the server block of the failed configuration to be unregistered")
+	}
+	browsers.release(keys[0])
+}
+
+func TestAdvertiserKeyCoversListenAddrs(t *testing.T) {
+	a := NewMdnsAdvertise("meshdns-node", "_dns._udp", 53, 60)
+	a.SetListenAddrs([]string{"dns://:53"})
+	b := NewMdnsAdvertise("meshdns-node", "_dns._udp", 53, 60)
+	b.SetListenAddrs([]string{"dns://192.168.1.2:53"})
+	if a.key() == b.key() {
+		t.Error("Expected advertisers with other listen addresses to have other keys")
+	}
+}
+
+func TestAdvertiserHandOver(t *testing.T) {
+	const state = "eth0#2=192.168.1.2/24"
+	const renamed = "meshdns-handover (2)" // after a conflict
+	server := &zeroconf.Server{}
+	newPrev := func() *MdnsAdvertise {
+		prev := NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1053, 60)
+		prev.started = true
+		prev.instanceName = renamed
+		prev.ifaceState = state
+		prev.registrations = []registration{{advertisedService: prev.services[0], server: server}}
+		return prev
+	}
+	withService := func(m *MdnsAdvertise) *MdnsAdvertise {
+		m.AddService("_other._tcp", 1054)
+		return m
+	}
+
+	testCases := []struct {
+		name        string
+		next        *MdnsAdvertise
+		instance    string // free instance name found by probing
+		state       string
+		expectTaken bool
+	}{
+		{
+			name:        "changed configuration",
+			next:        NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1053, 60),
+			instance:    renamed,
+			state:       state,
+			expectTaken: true,
+		},
+		{
+			name:     "other instance name",
+			next:     NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1053, 60),
+			instance: "meshdns-handover",
+			state:    state,
+		},
+		{
+			name:     "other service",
+			next:     NewMdnsAdvertise("meshdns-handover", "_other._udp", 1053, 60),
+			instance: renamed,
+			state:    state,
+		},
+		{
+			name:     "other port",
+			next:     NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1054, 60),
+			instance: renamed,
+			state:    state,
+		},
+		{
+			name:     "additional service",
+			next:     withService(NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1053, 60)),
+			instance: renamed,
+			state:    state,
+		},
+		{
+			name:     "other ttl",
+			next:     NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1053, 120),
+			instance: renamed,
+			state:    state,
+		},
+		{
+			name:     "other interfaces",
+			next:     NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1053, 60),
+			instance: renamed,
+			state:    "eth1#3=10.0.0.2/24",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prev := newPrev()
+			advertisers.acquire(t.Name(), prev)
+			defer advertisers.release(t.Name())
+
+			tc.next.SetMesh("changed")
+			taken, _ := tc.next.takeOver(tc.instance, tc.state)
+			if !tc.expectTaken {
+				if taken != nil || !prev.advertising() || prev.registrations == nil {
+					t.Fatal("Expected the previous advertisement to be left alone")
+				}
+				prev.registrations = nil // nothing was registered
+				return
+			}
+			if len(taken) != 1 || taken[0].server != server {
+				t.Fatalf("Expected the registration to be handed over, got %v", taken)
+			}
+			if prev.advertising() || prev.registrations != nil {
+				t.Error("Expected the previous advertisement to be stopped without its registration")
+			}
+		})
+	}
+}
+
+func TestAdvertiserHandOverHosts(t *testing.T) {
+	const state = "eth0#2=192.168.1.2/24"
+	newPrev := func() *MdnsAdvertise {
+		prev := NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1053, 60)
+		prev.AddHost("router", net.ParseIP("192.168.1.1"))
+		prev.started = true
+		prev.ifaceState = state
+		prev.registrations = []registration{{advertisedService: prev.services[0], server: &zeroconf.Server{}}}
+		prev.hosts = &hostResponder{stopCh: make(chan struct{})}
+		prev.hostsState = state
+		return prev
+	}
+
+	testCases := []struct {
+		name        string
+		host        net.IP
+		expectTaken bool
+	}{
+		{name: "same host records", host: net.ParseIP("192.168.1.1"), expectTaken: true},
+		{name: "other host records", host: net.ParseIP("192.168.1.254")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prev := newPrev()
+			hosts := prev.hosts
+			advertisers.acquire(t.Name(), prev)
+			defer advertisers.release(t.Name())
+
+			next := NewMdnsAdvertise("meshdns-handover", "_handover._udp", 1053, 60)
+			next.AddHost("router", tc.host)
+			registrations, taken := next.takeOver("meshdns-handover", state)
+			if registrations == nil {
+				t.Fatal("Expected the registrations to be handed over")
+			}
+			if tc.expectTaken != (taken == hosts) {
+				t.Errorf("Expected the host responder to be handed over: %t, got %v", tc.expectTaken, taken)
+			}
+			if prev.hosts != nil {
+				t.Error("Expected the previous host responder to be handed over or stopped")
+			}
+		})
+	}
+}
+
+func keysOf[T any](r *registry[T]) map[string]bool {
+	keys := make(map[string]bool)
+	for key := range r.entries {
+		keys[key] = true
+	}
+	return keys
+}
+
+// newKeys returns the keys registered since the snapshot was taken.
+func newKeys[T any](r *registry[T], before map[string]bool) []string {
+	keys := []string{}
+	for key := range r.entries {
+		if !before[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestSetupSharesBrowserBetweenZones(t *testing.T) {
+	before := keysOf(browsers)
+
+	for _, zone := range []string{"a.example.com", "b.example.com"} {
+		c := caddy.NewTestController("dns", "dnsmesh_mdns_forward "+zone+` {
+			type _shared._udp
+		}`)
+		if err := setupForward(c); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	keys := newKeys(browsers, before)
+	if len(keys) != 1 {
+		t.Fatalf("Expected a single shared browser, got %v", keys)
+	}
+	if refs := browsers.entries[keys[0]].refs; refs != 2 {
+		t.Errorf("Expected 2 references, got %d", refs)
+	}
+
+	browsers.release(keys[0])
+	browsers.release(keys[0])
+}
+
+func TestAdvertiserFor(t *testing.T) {
+	c := caddy.NewTestController("dns", `dnsmesh_mdns_advertise {
+		type _lookup._udp
+		port 1053
+	}`)
+	before := keysOf(advertisers)
+	if err := setupAdvertise(c); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	keys := newKeys(advertisers, before)
+	defer func() {
+		for _, key := range keys {
+			advertisers.release(key)
+		}
+	}()
+
+	config := dnsserver.GetConfig(c)
+	advertiser := AdvertiserFor(config)
+	if advertiser == nil || advertiser != advertisers.entries[keys[0]].value {
+		t.Fatal("Expected the advertiser of the server block")
+	}
+	if AdvertiserFor(&dnsserver.Config{}) != nil {
+		t.Error("Expected no advertiser for another server block")
+	}
+
+	unregisterServerAdvertiser(config)
+	if AdvertiserFor(config) != nil {
+		t.Error("Expected the advertiser to be removed")
+	}
+}
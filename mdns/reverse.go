@@ -0,0 +1,123 @@
+package mdns
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+)
+
+// Reverse zones which are routed to the peer owning the subnet of the address.
+const (
+	ReverseZoneIPv4 = "in-addr.arpa."
+	ReverseZoneIPv6 = "ip6.arpa."
+)
+
+// formatSubnets formats subnets for the subnets TXT entry.
+func formatSubnets(subnets []netip.Prefix) string {
+	strs := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		strs = append(strs, subnet.String())
+	}
+	return strings.Join(strs, ",")
+}
+
+// parseSubnets parses the subnets TXT entry of a peer. Invalid subnets are skipped.
+func parseSubnets(val string) []netip.Prefix {
+	subnets := []netip.Prefix{}
+	for _, str := range strings.Split(val, ",") {
+		subnet, err := netip.ParsePrefix(strings.TrimSpace(str))
+		if err != nil {
+			continue
+		}
+		subnets = append(subnets, subnet.Masked())
+	}
+	return subnets
+}
+
+// interfaceSubnets returns the subnets of the given interfaces, or of all
+// multicast capable interfaces when none are given. Loopback and link-local
+// subnets are skipped.
+func interfaceSubnets(ifaces []net.Interface) ([]netip.Prefix, error) {
+	if len(ifaces) == 0 {
+		all, err := multicastInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		ifaces = all
+	}
+
+	subnets := []netip.Prefix{}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			subnet, err := netip.ParsePrefix(addr.String())
+			if err != nil {
+				continue
+			}
+			ip := subnet.Addr()
+			if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			subnets = append(subnets, netip.PrefixFrom(ip.Unmap(), subnet.Bits()).Masked())
+		}
+	}
+	return subnets, nil
+}
+
+// reverseAddr returns the address a PTR query asks about, if the query is
+// for a name in one of the reverse zones.
+func reverseAddr(r *dns.Msg) (netip.Addr, bool) {
+	q := r.Question[0]
+	if q.Qtype != dns.TypePTR {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(dnsutil.ExtractAddressFromReverse(strings.ToLower(q.Name)))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// servicesForAddr returns the peers in the mesh advertising the most specific
+// subnet containing the address.
+func (m *MdnsForwardPlugin) servicesForAddr(addr netip.Addr) []*zeroconf.ServiceEntry {
+	bestBits := -1
+	best := []*zeroconf.ServiceEntry{}
+	for _, service := range m.browser.PartitionServices(m.Mesh) {
+		val, ok := txtValue(service.Text, TxtSubnets)
+		if !ok {
+			continue
+		}
+		bits := -1
+		for _, subnet := range parseSubnets(val) {
+			if subnet.Contains(addr) && subnet.Bits() > bits {
+				bits = subnet.Bits()
+			}
+		}
+		switch {
+		case bits < 0 || bits < bestBits:
+			continue
+		case bits > bestBits:
+			bestBits = bits
+			best = []*zeroconf.ServiceEntry{service}
+		default:
+			best = append(best, service)
+		}
+	}
+	return best
+}
+
+// reverseZone returns the reverse zone for an address.
+func reverseZone(addr netip.Addr) string {
+	if addr.Is4() {
+		return ReverseZoneIPv4
+	}
+	return ReverseZoneIPv6
+}
@@ -0,0 +1,91 @@
+package mdns
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+)
+
+func TestReverseAddr(t *testing.T) {
+	testCases := []struct {
+		name     string
+		qname    string
+		qtype    uint16
+		expected string
+	}{
+		{name: "ipv4", qname: "5.2.168.192.in-addr.arpa.", qtype: dns.TypePTR, expected: "192.168.2.5"},
+		{name: "ipv4 upper case", qname: "5.2.168.192.IN-ADDR.ARPA.", qtype: dns.TypePTR, expected: "192.168.2.5"},
+		{
+			name:     "ipv6",
+			qname:    "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.",
+			qtype:    dns.TypePTR,
+			expected: "fd00::1",
+		},
+		{name: "not ptr", qname: "5.2.168.192.in-addr.arpa.", qtype: dns.TypeA},
+		{name: "partial name", qname: "2.168.192.in-addr.arpa.", qtype: dns.TypePTR},
+		{name: "forward name", qname: "host.example.com.", qtype: dns.TypePTR},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(dns.Msg).SetQuestion(tc.qname, tc.qtype)
+			addr, ok := reverseAddr(r)
+			if tc.expected == "" {
+				if ok {
+					t.Fatalf("Expected no address, got %s", addr)
+				}
+				return
+			}
+			if !ok || addr != netip.MustParseAddr(tc.expected) {
+				t.Errorf("Expected %s, got %s (ok: %v)", tc.expected, addr, ok)
+			}
+		})
+	}
+}
+
+func TestParseSubnets(t *testing.T) {
+	subnets := parseSubnets("192.168.2.7/24, fd00::/64,bogus")
+	expected := []netip.Prefix{netip.MustParsePrefix("192.168.2.0/24"), netip.MustParsePrefix("fd00::/64")}
+	if !reflect.DeepEqual(subnets, expected) {
+		t.Fatalf("Expected %v, got %v", expected, subnets)
+	}
+	if val := formatSubnets(subnets); val != "192.168.2.0/24,fd00::/64" {
+		t.Errorf("Unexpected formatted subnets: %s", val)
+	}
+}
+
+func TestServicesForAddr(t *testing.T) {
+	wide := &zeroconf.ServiceEntry{ServiceRecord: zeroconf.ServiceRecord{Instance: "wide"}, Text: []string{"subnets=192.168.0.0/16"}}
+	narrow := &zeroconf.ServiceEntry{ServiceRecord: zeroconf.ServiceRecord{Instance: "narrow"}, Text: []string{"subnets=10.0.0.0/8,192.168.2.0/24"}}
+	narrow2 := &zeroconf.ServiceEntry{ServiceRecord: zeroconf.ServiceRecord{Instance: "narrow2"}, Text: []string{"subnets=192.168.2.0/24"}}
+	v6 := &zeroconf.ServiceEntry{ServiceRecord: zeroconf.ServiceRecord{Instance: "v6"}, Text: []string{"subnets=fd00::/64"}}
+	none := &zeroconf.ServiceEntry{ServiceRecord: zeroconf.ServiceRecord{Instance: "none"}}
+
+	m := MdnsForwardPlugin{browser: &fakeBrowser{services: []*zeroconf.ServiceEntry{wide, narrow, narrow2, v6, none}}}
+
+	testCases := []struct {
+		addr     string
+		expected []string
+	}{
+		{addr: "192.168.2.5", expected: []string{"narrow", "narrow2"}},
+		{addr: "192.168.3.5", expected: []string{"wide"}},
+		{addr: "10.1.2.3", expected: []string{"narrow"}},
+		{addr: "fd00::1", expected: []string{"v6"}},
+		{addr: "172.16.0.1", expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.addr, func(t *testing.T) {
+			instances := []string{}
+			for _, service := range m.servicesForAddr(netip.MustParseAddr(tc.addr)) {
+				instances = append(instances, service.Instance)
+			}
+			if !reflect.DeepEqual(instances, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, instances)
+			}
+		})
+	}
+}
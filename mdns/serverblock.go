@@ -0,0 +1,102 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+)
+
+// serverBlockZones returns the zones served by a server block, in the order of
+// its keys and without duplicates.
+func serverBlockZones(keys []string) []string {
+	zones := []string{}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		for _, zone := range plugin.Host(key).NormalizeExact() {
+			if !seen[zone] {
+				seen[zone] = true
+				zones = append(zones, zone)
+			}
+		}
+	}
+	return zones
+}
+
+// serverBlockListenAddrs returns the addresses a server block listens on as
+// transport://host:port, for every key and listen host.
+func serverBlockListenAddrs(keys []string, listenHosts []string) []string {
+	if len(listenHosts) == 0 {
+		listenHosts = []string{""}
+	}
+
+	addrs := []string{}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		trans, port, ok := keyTransportPort(key)
+		if !ok {
+			continue
+		}
+		for _, host := range listenHosts {
+			addr := trans + "://" + net.JoinHostPort(host, port)
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+// serverBlockPort returns the port a server block serves plain DNS on, taken
+// from its first dns:// key. Without keys the default CoreDNS port is used.
+func serverBlockPort(keys []string) (int, error) {
+	if len(keys) == 0 {
+		return parsePort(dnsserver.Port)
+	}
+	for _, key := range keys {
+		trans, port, ok := keyTransportPort(key)
+		if ok && trans == transport.DNS {
+			return parsePort(port)
+		}
+	}
+	return 0, fmt.Errorf("no plain DNS port found in server block keys %v, set the port option", keys)
+}
+
+// parsePort parses a port number between 1 and 65535.
+func parsePort(val string) (int, error) {
+	port, err := strconv.Atoi(val)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %q", val)
+	}
+	return port, nil
+}
+
+// keyTransportPort returns the transport and port of a server block key,
+// falling back to the default port of the transport.
+func keyTransportPort(key string) (string, string, bool) {
+	trans, addr := parse.Transport(key)
+	_, port, err := plugin.SplitHostPort(addr)
+	if err != nil {
+		return "", "", false
+	}
+	if port == "" {
+		switch trans {
+		case transport.TLS:
+			port = transport.TLSPort
+		case transport.QUIC:
+			port = transport.QUICPort
+		case transport.GRPC:
+			port = transport.GRPCPort
+		case transport.HTTPS:
+			port = transport.HTTPSPort
+		default:
+			port = dnsserver.Port
+		}
+	}
+	return trans, port, true
+}
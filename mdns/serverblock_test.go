@@ -0,0 +1,84 @@
+package mdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerBlockZones(t *testing.T) {
+	keys := []string{"example.org:1053", "dns://example.com", "tls://example.org:853", "10.0.0.0/24", "."}
+	expected := []string{"example.org.", "example.com.", "0.0.10.in-addr.arpa.", "."}
+	if zones := serverBlockZones(keys); !reflect.DeepEqual(zones, expected) {
+		t.Errorf("Unexpected zones:\n- Want: %v\n- Got:  %v", expected, zones)
+	}
+}
+
+func TestServerBlockListenAddrs(t *testing.T) {
+	testCases := []struct {
+		name        string
+		keys        []string
+		listenHosts []string
+		expected    []string
+	}{
+		{
+			name:     "default port",
+			keys:     []string{"example.org", "example.com"},
+			expected: []string{"dns://:53"},
+		},
+		{
+			name:     "transports",
+			keys:     []string{"example.org:1053", "tls://example.org", "https://example.org:8443"},
+			expected: []string{"dns://:1053", "tls://:853", "https://:8443"},
+		},
+		{
+			name:        "listen hosts",
+			keys:        []string{".:53"},
+			listenHosts: []string{"127.0.0.1", "::1"},
+			expected:    []string{"dns://127.0.0.1:53", "dns://[::1]:53"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addrs := serverBlockListenAddrs(tc.keys, tc.listenHosts)
+			if !reflect.DeepEqual(addrs, tc.expected) {
+				t.Errorf("Unexpected listen addresses:\n- Want: %v\n- Got:  %v", tc.expected, addrs)
+			}
+		})
+	}
+}
+
+func TestServerBlockPort(t *testing.T) {
+	testCases := []struct {
+		name     string
+		keys     []string
+		expected int
+	}{
+		{name: "no keys", expected: 53},
+		{name: "root zone", keys: []string{"."}, expected: 53},
+		{name: "zone without port", keys: []string{"example.org"}, expected: 53},
+		{name: "dns scheme without port", keys: []string{"dns://example.org"}, expected: 53},
+		{name: "non-default port", keys: []string{"example.org:1053"}, expected: 1053},
+		{name: "normalized key", keys: []string{"dns://example.org.:1053"}, expected: 1053},
+		{name: "reverse zone", keys: []string{"10.0.0.0/24:5300"}, expected: 5300},
+		{name: "first plain dns key", keys: []string{"tls://example.org", "example.net:5300", "example.com:5400"}, expected: 5300},
+		{name: "only tls", keys: []string{"tls://example.org"}},
+		{name: "port zero", keys: []string{"example.org:0"}},
+		{name: "bad port", keys: []string{"example.org:99999"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			port, err := serverBlockPort(tc.keys)
+			if tc.expected == 0 {
+				if err == nil {
+					t.Fatalf("Expected an error, got port %d", port)
+				}
+				return
+			}
+			if err != nil || port != tc.expected {
+				t.Errorf("Expected %d, got %d (err: %v)", tc.expected, port, err)
+			}
+		})
+	}
+}
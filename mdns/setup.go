@@ -1,11 +1,15 @@
 package mdns
 
 import (
-	"errors"
+	"crypto/ed25519"
+	"maps"
+	"math"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +17,8 @@ import (
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
 
 	"github.com/nbeirne/coredns-dnsmesh/mdns/browser"
 )
@@ -24,6 +30,7 @@ import (
 func init() {
 	plugin.Register(ForwardPluginName, setupForward)
 	plugin.Register(AdvertisePluginName, setupAdvertise)
+	plugin.Register(ProxyPluginName, setupProxy)
 }
 
 type interfaceFinder func(net.IPNet) ([]net.Interface, error)
@@ -36,6 +43,11 @@ func setupForward(c *caddy.Controller) error {
 		return err
 	}
 
+	// Share one browser between all directives browsing the same services,
+	// and with the previous configuration across reloads.
+	browserKey := m.browser.(*browser.ZeroconfBrowser).Key()
+	m.browser = browsers.acquire(browserKey, m.browser)
+
 	// Add the Plugin to CoreDNS, so Servers can use it in their plugin chain.
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		m.Next = next
@@ -46,18 +58,47 @@ func setupForward(c *caddy.Controller) error {
 		return m
 	})
 
-	c.OnShutdown(func() error {
-		m.browser.Stop()
-		return nil
-	})
+	onRelease(c, func() { browsers.release(browserKey) })
 
 	// All OK, return a nil error.
 	return nil
 }
 
+func setupProxy(c *caddy.Controller) error {
+	p, err := parseProxyOptions(c, FindInterfacesForSubnet)
+	if err != nil {
+		return err
+	}
+
+	// Browsers are shared like those of dnsmesh_mdns_forward.
+	keys := []string{}
+	for serviceType, b := range p.browsers {
+		key := b.(*browser.ZeroconfBrowser).Key()
+		p.browsers[serviceType] = browsers.acquire(key, b)
+		keys = append(keys, key)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		p.Next = next
+		if err := p.Start(); err != nil {
+			log.Error(err)
+			return nil
+		}
+		return p
+	})
+
+	onRelease(c, func() {
+		for _, key := range keys {
+			browsers.release(key)
+		}
+	})
+
+	return nil
+}
+
 func setupAdvertise(c *caddy.Controller) error {
 	// Defaults
-	mdnsType := DefaultServiceType
+	services := []advertisedService{}
 	ttl := DefaultTTL
 
 	shortHostname, err := getShortHostname()
@@ -66,12 +107,23 @@ func setupAdvertise(c *caddy.Controller) error {
 	}
 	instanceName := AdvertisingPrefix + shortHostname
 
-	port, err := getServerPort(c)
-	if err != nil {
-		port = 0
-	}
-
-	ifaceBindSubnet := (*net.IPNet)(nil)
+	port := 0 // taken from the server block unless configured
+	ifaceSelector := interfaceSelector{}
+	tsig := (*tsigKey)(nil)
+	nodeID := shortHostname
+	mesh := ""
+	signingKey := ed25519.PrivateKey(nil)
+	subnets := []netip.Prefix(nil)
+	autoSubnets := false
+	txtEntries := []string{}
+	txtKeys := make(map[string]bool)
+	healthCheck := HealthCheck(nil)
+	hosts := map[string][]net.IP{}
+	hostNames := []string{}
+	healthInterval := DefaultHealthInterval
+	healthFailures := DefaultHealthFailures
+	healthSuccesses := DefaultHealthSuccesses
+	drain := time.Duration(0)
 
 	c.Next()
 	for c.NextBlock() {
@@ -84,18 +136,31 @@ func setupAdvertise(c *caddy.Controller) error {
 			instanceName = val
 
 		case "type":
-			val, err := parseSingleArg(c)
-			if err != nil {
-				return err
+			args := c.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return c.Errf("option 'type' expects a service type and an optional port")
 			}
-			mdnsType = val
+			service := advertisedService{service: args[0]}
+			if len(args) == 2 {
+				portInt, err := parsePort(args[1])
+				if err != nil {
+					return c.Errf("port provided for type %s is invalid: %s", args[0], args[1])
+				}
+				service.port = portInt
+			}
+			for _, s := range services {
+				if strings.EqualFold(s.service, service.service) {
+					return c.Errf("type %s is advertised more than once", service.service)
+				}
+			}
+			services = append(services, service)
 
 		case "port":
 			val, err := parseSingleArg(c)
 			if err != nil {
 				return err
 			}
-			portInt, err := strconv.Atoi(val)
+			portInt, err := parsePort(val)
 			if err != nil {
 				return c.Errf("port provided is invalid: %s", val)
 			}
@@ -112,33 +177,249 @@ func setupAdvertise(c *caddy.Controller) error {
 			}
 			ttl = uint32(ttlInt)
 
-		case "iface_bind_subnet":
+		case "iface_bind_subnet", "iface", "exclude_iface":
+			if err := parseInterfaceOption(c, &ifaceSelector); err != nil {
+				return err
+			}
+
+		case "tsig":
+			key, err := parseTsigKey(c)
+			if err != nil {
+				return err
+			}
+			tsig = key
+
+		case "mesh":
+			val, err := parseSingleArg(c)
+			if err != nil {
+				return err
+			}
+			mesh = val
+
+		case "node_id":
+			val, err := parseSingleArg(c)
+			if err != nil {
+				return err
+			}
+			nodeID = val
+
+		case "signing_key":
 			val, err := parseSingleArg(c)
 			if err != nil {
 				return err
 			}
-			_, subnet, err := net.ParseCIDR(val)
+			key, err := parseSigningKey(val)
 			if err != nil {
-				return c.Errf("Failed to parse subnet: %s", val)
+				return c.Errf("signing_key is not a valid base64 Ed25519 key: %v", err)
 			}
-			ifaceBindSubnet = subnet
+			signingKey = key
+
+		case "txt":
+			args := c.RemainingArgs()
+			if len(args) != 2 {
+				return c.Errf("option 'txt' expects a key and a value")
+			}
+			if err := validateTxtEntry(args[0], args[1]); err != nil {
+				return c.Errf("%v", err)
+			}
+			if txtKeys[strings.ToLower(args[0])] {
+				return c.Errf("txt key %q is set more than once", args[0])
+			}
+			txtKeys[strings.ToLower(args[0])] = true
+			txtEntries = append(txtEntries, txtEntry(args[0], args[1]))
+
+		case "subnets":
+			option := c.Val()
+			args := c.RemainingArgs()
+			if len(args) == 1 && args[0] == "auto" {
+				autoSubnets = true
+				continue
+			}
+			prefixes, err := parsePrefixArgs(c, option, args)
+			if err != nil {
+				return err
+			}
+			subnets = append(subnets, prefixes...)
+
+		case "health_check":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return c.Errf("option 'health_check' expects 'dns <name> [type]' or 'http <url>'")
+			}
+			switch {
+			case args[0] == "dns" && (len(args) == 2 || len(args) == 3):
+				qtype := dns.TypeA
+				if len(args) == 3 {
+					t, ok := dns.StringToType[strings.ToUpper(args[2])]
+					if !ok {
+						return c.Errf("unknown record type for health_check: %s", args[2])
+					}
+					qtype = t
+				}
+				healthCheck = &dnsHealthCheck{name: dns.Fqdn(args[1]), qtype: qtype}
+			case args[0] == "http" && len(args) == 2:
+				u, err := url.Parse(args[1])
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					return c.Errf("invalid url for health_check: %s", args[1])
+				}
+				healthCheck = &httpHealthCheck{url: args[1]}
+			default:
+				return c.Errf("option 'health_check' expects 'dns <name> [type]' or 'http <url>'")
+			}
+
+		case "hosts":
+			if err := parseHostsBlock(c, hosts, &hostNames); err != nil {
+				return err
+			}
+
+		case "health_interval":
+			val, err := parseSingleArg(c)
+			if err != nil {
+				return err
+			}
+			interval, err := time.ParseDuration(val)
+			if err != nil || interval <= 0 {
+				return c.Errf("invalid duration for health_interval: %s", val)
+			}
+			healthInterval = interval
+
+		case "drain":
+			val, err := parseSingleArg(c)
+			if err != nil {
+				return err
+			}
+			d, err := time.ParseDuration(val)
+			if err != nil || d <= 0 {
+				return c.Errf("invalid duration for drain: %s", val)
+			}
+			drain = d
+
+		case "health_threshold":
+			args := c.RemainingArgs()
+			if len(args) != 2 {
+				return c.Errf("option 'health_threshold' expects a failure and a success count")
+			}
+			failures, err := strconv.Atoi(args[0])
+			if err != nil || failures < 1 {
+				return c.Errf("invalid failure count for health_threshold: %s", args[0])
+			}
+			successes, err := strconv.Atoi(args[1])
+			if err != nil || successes < 1 {
+				return c.Errf("invalid success count for health_threshold: %s", args[1])
+			}
+			healthFailures, healthSuccesses = failures, successes
 
 		default:
 			return c.Errf("Unknown option: %s", c.Val())
 		}
 	}
 
-	// TODO: configure
-	advertiser := NewMdnsAdvertise(instanceName, mdnsType, port, ttl)
-	advertiser.BindToSubnet(ifaceBindSubnet)
+	// Verify and sign queries from mesh peers which are signed with the mesh key.
+	if tsig != nil {
+		if err := checkTsigOrder(dnsserver.Directives); err != nil {
+			return c.Errf("%v", err)
+		}
+		config := dnsserver.GetConfig(c)
+		if config.TsigSecret == nil {
+			config.TsigSecret = make(map[string]string)
+		}
+		for name, secret := range tsig.secrets() {
+			config.TsigSecret[name] = secret
+		}
+		config.AddPlugin(func(next plugin.Handler) plugin.Handler {
+			return &tsigHandler{Next: next}
+		})
+	}
+
+	if len(services) == 0 {
+		services = append(services, advertisedService{service: DefaultServiceType})
+	}
+	// Types without their own port use the port of the server block.
+	for i := range services {
+		if services[i].port != 0 {
+			continue
+		}
+		if port == 0 {
+			blockPort, err := serverBlockPort(c.ServerBlockKeys)
+			if err != nil {
+				return c.Errf("%v", err)
+			}
+			port = blockPort
+		}
+		services[i].port = port
+	}
+
+	advertiser := NewMdnsAdvertise(instanceName, services[0].service, services[0].port, ttl)
+	for _, s := range services[1:] {
+		advertiser.AddService(s.service, s.port)
+	}
+	for _, subnet := range ifaceSelector.subnets {
+		advertiser.BindToSubnet(subnet)
+	}
+	for _, name := range ifaceSelector.names {
+		advertiser.BindToInterface(name)
+	}
+	for _, name := range ifaceSelector.excludes {
+		advertiser.ExcludeInterface(name)
+	}
+	advertiser.SetNodeID(nodeID)
+	advertiser.SetMesh(mesh)
+	advertiser.SetSubnets(subnets)
+	advertiser.SetZones(serverBlockZones(c.ServerBlockKeys))
+	// The bind plugin comes first in plugin.cfg, so the listen hosts are set.
+	config := dnsserver.GetConfig(c)
+	advertiser.SetListenAddrs(serverBlockListenAddrs(c.ServerBlockKeys, config.ListenHosts))
+	for _, entry := range txtEntries {
+		if err := advertiser.AddTxt(entry); err != nil {
+			return c.Errf("%v", err)
+		}
+	}
+	if autoSubnets {
+		advertiser.AutoSubnets()
+	}
+	if signingKey != nil {
+		advertiser.SignWith(signingKey)
+	}
+	for _, name := range hostNames {
+		advertiser.AddHost(name, hosts[name]...)
+	}
+	if healthCheck != nil {
+		advertiser.SetHealthCheck(healthCheck, healthInterval, healthFailures, healthSuccesses)
+	}
+	// The zones and listen addresses of the server block are published as
+	// well, so check the complete record.
+	if err := validateTxtRecord(advertiser.text(services[0].port)); err != nil {
+		return c.Errf("%v", err)
+	}
+	if drain > 0 {
+		advertiser.SetDrain(drain)
+		drainOnce.Do(func() {
+			caddy.RegisterEventHook(AdvertisePluginName+"_drain", drainOnShutdown)
+		})
+	}
+
+	// Keep advertising without interruption across reloads.
+	advertiserKey := advertiser.key()
+	advertiser = advertisers.acquire(advertiserKey, advertiser)
+	registerServerAdvertiser(config, advertiser)
 
 	c.OnStartup(func() error {
+		if advertiser.advertising() {
+			return nil
+		}
 		return advertiser.StartAdvertise()
 	})
 
-	c.OnShutdown(func() error {
-		advertiser.StopAdvertise()
-		return nil
+	onRelease(c, func() {
+		unregisterServerAdvertiser(config)
+		advertisers.release(advertiserKey)
+	})
+	// A failed reload may have taken the announcement over, see handOver.
+	c.OnRestartFailed(func() error {
+		if advertiser.advertising() {
+			return nil
+		}
+		return advertiser.StartAdvertise()
 	})
 
 	return nil
@@ -154,23 +435,119 @@ func getShortHostname() (string, error) {
 	return shortName, nil
 }
 
-func getServerPort(c *caddy.Controller) (int, error) {
-	keys := c.ServerBlockKeys
-	if len(keys) == 0 {
-		return 0, errors.New("error fetching port from server block")
+// parseHostsBlock parses a hosts-style block of "<address> <name>..." lines
+// into the addresses of each name, keeping the order names first appear in.
+// The block must open on the line of the option and close on its own line.
+func parseHostsBlock(c *caddy.Controller, hosts map[string][]net.IP, names *[]string) error {
+	if !c.NextArg() || c.Val() != "{" {
+		return c.Errf("option 'hosts' expects a block")
+	}
+	for c.Next() {
+		if c.Val() == "}" {
+			return nil
+		}
+		ip := net.ParseIP(c.Val())
+		if ip == nil {
+			return c.Errf("invalid address in hosts: %s", c.Val())
+		}
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.Errf("address %s in hosts has no names", ip)
+		}
+		for _, arg := range args {
+			name := hostName(arg)
+			if _, ok := dns.IsDomainName(name); !ok || arg == "}" {
+				return c.Errf("invalid name in hosts: %s", arg)
+			}
+			if _, ok := hosts[name]; !ok {
+				*names = append(*names, name)
+			}
+			hosts[name] = append(hosts[name], ip)
+		}
 	}
-	log.Debugf("got key: %v", keys)
+	return c.Errf("option 'hosts' is missing a closing brace")
+}
 
-	urlStr := keys[0]
-	url, err := url.Parse(urlStr)
-	if err != nil {
-		log.Errorf("Error parsing port from address %s", urlStr)
-		return 0, err
+func parseProxyOptions(c *caddy.Controller, findIfaces interfaceFinder) (*MdnsProxyPlugin, error) {
+	p := MdnsProxyPlugin{ttl: DefaultProxyTTL}
+	ifaceSelector := interfaceSelector{}
+
+	for c.Next() {
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, plugin.Error(ProxyPluginName, c.Errf("a zone must be specified"))
+		}
+		p.Zone = dns.Fqdn(strings.ToLower(args[0]))
+		if _, ok := dns.IsDomainName(p.Zone); !ok || p.Zone == "." {
+			return nil, plugin.Error(ProxyPluginName, c.Errf("invalid zone: %s", args[0]))
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "type":
+				serviceTypes := c.RemainingArgs()
+				if len(serviceTypes) == 0 {
+					return nil, plugin.Error(ProxyPluginName, c.Errf("option 'type' expects at least one service type"))
+				}
+				for _, serviceType := range serviceTypes {
+					serviceType = strings.ToLower(strings.Trim(serviceType, "."))
+					if !validServiceType(serviceType) {
+						return nil, plugin.Error(ProxyPluginName, c.Errf("invalid service type: %s", serviceType))
+					}
+					if !slices.Contains(p.types, serviceType) {
+						p.types = append(p.types, serviceType)
+					}
+				}
+
+			case "ttl":
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ProxyPluginName, err)
+				}
+				ttl, err := strconv.ParseUint(val, 10, 32)
+				if err != nil || ttl == 0 {
+					return nil, plugin.Error(ProxyPluginName, c.Errf("ttl provided is invalid: %s", val))
+				}
+				p.ttl = uint32(ttl)
+
+			case "iface_bind_subnet", "iface", "exclude_iface":
+				if err := parseInterfaceOption(c, &ifaceSelector); err != nil {
+					return nil, plugin.Error(ProxyPluginName, err)
+				}
+
+			default:
+				return nil, plugin.Error(ProxyPluginName, c.Errf("unknown option: %s", c.Val()))
+			}
+		}
+	}
+	if len(p.types) == 0 {
+		return nil, plugin.Error(ProxyPluginName, c.Errf("at least one service type must be configured with 'type'"))
+	}
+
+	var ifaces *[]net.Interface
+	if !ifaceSelector.empty() {
+		foundIfaces, err := ifaceSelector.interfaces(findIfaces)
+		if err != nil || len(foundIfaces) == 0 {
+			log.Errorf("Failed to find interfaces for '%s'\n", &ifaceSelector)
+			foundIfaces = []net.Interface{}
+		}
+		ifaces = &foundIfaces
 	}
 
-	port, err := strconv.Atoi(url.Port())
+	p.browsers = make(map[string]browser.MdnsBrowserInterface)
+	for _, serviceType := range p.types {
+		p.browsers[serviceType] = newProxyBrowser(serviceType, ifaces)
+	}
+	p.lookupHost = browser.NewZeroconfSession(browser.ZeroconfImpl{}, ifaces).LookupHost
+	return &p, nil
+}
 
-	return port, err
+// validServiceType reports whether a service type has the form
+// _service._tcp or _service._udp of RFC 6763 section 7.
+func validServiceType(serviceType string) bool {
+	name, proto, ok := strings.Cut(serviceType, ".")
+	return ok && len(name) > 1 && name[0] == '_' && !strings.Contains(name[1:], "_") &&
+		(proto == "_tcp" || proto == "_udp")
 }
 
 func parseSingleArg(c *caddy.Controller) (string, error) {
@@ -190,13 +567,65 @@ func parseSingleArg(c *caddy.Controller) (string, error) {
 	return val, nil
 }
 
+// parsePrefixes parses one or more CIDR arguments of the current option.
+func parsePrefixes(c *caddy.Controller) ([]netip.Prefix, error) {
+	optionName := c.Val()
+	return parsePrefixArgs(c, optionName, c.RemainingArgs())
+}
+
+func parsePrefixArgs(c *caddy.Controller, optionName string, args []string) ([]netip.Prefix, error) {
+	if len(args) == 0 {
+		return nil, c.Errf("option '%s' expects at least one subnet", optionName)
+	}
+
+	prefixes := []netip.Prefix{}
+	for _, arg := range args {
+		prefix, err := netip.ParsePrefix(arg)
+		if err != nil {
+			return nil, c.Errf("failed to parse subnet for '%s': %s", optionName, arg)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseRateLimit parses the queries per second and optional burst arguments
+// of the current option. The burst defaults to the rate, rounded up.
+func parseRateLimit(c *caddy.Controller) (rate.Limit, int, error) {
+	optionName := c.Val()
+
+	args := c.RemainingArgs()
+	if len(args) < 1 || len(args) > 2 {
+		return 0, 0, c.Errf("option '%s' expects a rate and an optional burst", optionName)
+	}
+	limit, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || limit <= 0 {
+		return 0, 0, c.Errf("invalid rate for '%s': %s", optionName, args[0])
+	}
+	burst := int(math.Ceil(limit))
+	if len(args) == 2 {
+		burst, err = strconv.Atoi(args[1])
+		if err != nil || burst < 1 {
+			return 0, 0, c.Errf("invalid burst for '%s': %s", optionName, args[1])
+		}
+	}
+	return rate.Limit(limit), burst, nil
+}
+
 func parseForwardOptions(c *caddy.Controller, findIfaces interfaceFinder) (*MdnsForwardPlugin, error) {
 	m := MdnsForwardPlugin{}
 
 	mdnsType := DefaultServiceType
-	ifaceBindSubnet := (*net.IPNet)(nil)
+	ifaceSelector := interfaceSelector{}
+	trustedKeys := []ed25519.PublicKey{}
+	signatureMaxAge := DefaultSignatureMaxAge
+	rateLimitIPv4Bits := DefaultRateLimitIPv4Bits
+	rateLimitIPv6Bits := DefaultRateLimitIPv6Bits
+	rateLimitAction := RefuseAction
 
 	m.Timeout = DefaultTimeout
+	m.PeerTimeout = DefaultPeerTimeout
+	m.refreshTimeout = DefaultRefreshTimeout
 	m.addrsPerHost = DefaultAddrsPerHost
 	m.addrMode = DefaultAddrMode
 
@@ -216,16 +645,17 @@ func parseForwardOptions(c *caddy.Controller, findIfaces interfaceFinder) (*Mdns
 				}
 				mdnsType = val
 
-			case "iface_bind_subnet":
+			case "mesh":
 				val, err := parseSingleArg(c)
 				if err != nil {
 					return nil, plugin.Error(ForwardPluginName, err)
 				}
-				_, subnet, err := net.ParseCIDR(val)
-				if err != nil {
-					return nil, plugin.Error(ForwardPluginName, c.Errf("failed to parse subnet: %s", val))
+				m.Mesh = val
+
+			case "iface_bind_subnet", "iface", "exclude_iface":
+				if err := parseInterfaceOption(c, &ifaceSelector); err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
 				}
-				ifaceBindSubnet = subnet
 
 			case "ignore_self":
 				val, err := parseSingleArg(c)
@@ -249,6 +679,54 @@ func parseForwardOptions(c *caddy.Controller, findIfaces interfaceFinder) (*Mdns
 				}
 				m.filter = filter
 
+			case "exclude":
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				exclude, err := regexp.Compile(val)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("failed to compile regex for 'exclude': %s", val))
+				}
+				m.exclude = exclude
+
+			case "hostname":
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				hostnameFilter, err := regexp.Compile(val)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("failed to compile regex for 'hostname': %s", val))
+				}
+				m.hostnameFilter = hostnameFilter
+
+			case "require_txt":
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				key, value, _ := strings.Cut(val, "=")
+				if key == "" {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("require_txt expects key=value: %s", val))
+				}
+				if m.requireTxt == nil {
+					m.requireTxt = make(map[string]string)
+				}
+				m.requireTxt[key] = value
+
+			case "allow_cidr", "deny_cidr":
+				option := c.Val()
+				prefixes, err := parsePrefixes(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				if option == "allow_cidr" {
+					m.allowCidrs = append(m.allowCidrs, prefixes...)
+				} else {
+					m.denyCidrs = append(m.denyCidrs, prefixes...)
+				}
+
 			case "address_mode":
 				val, err := parseSingleArg(c)
 				if err != nil {
@@ -278,16 +756,24 @@ func parseForwardOptions(c *caddy.Controller, findIfaces interfaceFinder) (*Mdns
 				}
 				m.addrsPerHost = addrsPerHostInt
 
-			case "timeout":
+			case "timeout", "total_timeout", "peer_timeout", "refresh_timeout":
+				option := c.Val()
 				val, err := parseSingleArg(c)
 				if err != nil {
 					return nil, plugin.Error(ForwardPluginName, err)
 				}
 				timeout, err := time.ParseDuration(val)
-				if err != nil {
-					return nil, plugin.Error(ForwardPluginName, c.Errf("invalid duration for timeout: %s", val))
+				if err != nil || timeout <= 0 {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("invalid duration for %s: %s", option, val))
+				}
+				switch option {
+				case "timeout", "total_timeout":
+					m.Timeout = timeout
+				case "peer_timeout":
+					m.PeerTimeout = timeout
+				case "refresh_timeout":
+					m.refreshTimeout = timeout
 				}
-				m.Timeout = timeout
 
 			case "attempts":
 				val, err := parseSingleArg(c)
@@ -311,32 +797,202 @@ func parseForwardOptions(c *caddy.Controller, findIfaces interfaceFinder) (*Mdns
 				}
 				m.WorkerCount = workerCount
 
+			case "allow_qtypes", "deny_qtypes":
+				option := c.Val()
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("option '%s' expects at least one query type", option))
+				}
+				qtypes, err := parseQtypes(c, args)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				// Repeated options add to the query types, like the CIDR options.
+				policy := &m.allowQtypes
+				if option == "deny_qtypes" {
+					policy = &m.denyQtypes
+				}
+				if *policy == nil {
+					*policy = qtypes
+				} else {
+					maps.Copy(*policy, qtypes)
+				}
+
+			case "minimal_any":
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				minimalAny, err := strconv.ParseBool(val)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("failed to parse boolean for 'minimal_any': %s", val))
+				}
+				m.minimalAny = minimalAny
+
+			case "policy":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("option 'policy' expects a policy"))
+				}
+				policy, ok := policyNames[args[0]]
+				if !ok {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("unknown policy: %s", args[0]))
+				}
+				if len(args) == 1 {
+					m.policy = policy
+					break
+				}
+				qtypes, err := parseQtypes(c, args[1:])
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				if m.qtypePolicies == nil {
+					m.qtypePolicies = make(map[uint16]int)
+				}
+				for qtype := range qtypes {
+					m.qtypePolicies[qtype] = policy
+				}
+
+			case "min_ttl", "max_ttl":
+				option := c.Val()
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				ttl, err := strconv.ParseUint(val, 10, 32)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("%s provided is invalid: %s", option, val))
+				}
+				if option == "min_ttl" {
+					m.minTTL = uint32(ttl)
+				} else {
+					m.maxTTL = uint32(ttl)
+				}
+
+			case "peer_lifetime_ttl":
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				peerLifetimeTTL, err := strconv.ParseBool(val)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("failed to parse boolean for 'peer_lifetime_ttl': %s", val))
+				}
+				m.peerLifetimeTTL = peerLifetimeTTL
+
+			case "client_rate_limit", "global_rate_limit":
+				option := c.Val()
+				limit, burst, err := parseRateLimit(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				if m.rateLimiter == nil {
+					m.rateLimiter = newRateLimiter()
+				}
+				if option == "client_rate_limit" {
+					m.rateLimiter.clientRate = limit
+					m.rateLimiter.clientBurst = burst
+				} else {
+					m.rateLimiter.global = rate.NewLimiter(limit, burst)
+				}
+
+			case "client_rate_limit_prefix":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("option 'client_rate_limit_prefix' expects an IPv4 and an IPv6 prefix length"))
+				}
+				ipv4Bits, err := strconv.Atoi(args[0])
+				if err != nil || ipv4Bits < 0 || ipv4Bits > 32 {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("invalid IPv4 prefix length for 'client_rate_limit_prefix': %s", args[0]))
+				}
+				ipv6Bits, err := strconv.Atoi(args[1])
+				if err != nil || ipv6Bits < 0 || ipv6Bits > 128 {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("invalid IPv6 prefix length for 'client_rate_limit_prefix': %s", args[1]))
+				}
+				rateLimitIPv4Bits, rateLimitIPv6Bits = ipv4Bits, ipv6Bits
+
+			case "rate_limit_action":
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				switch val {
+				case "refuse":
+					rateLimitAction = RefuseAction
+				case "fallthrough":
+					rateLimitAction = FallthroughAction
+				default:
+					return nil, plugin.Error(ForwardPluginName, c.Errf("unknown rate_limit_action: %s", val))
+				}
+
+			case "tsig":
+				key, err := parseTsigKey(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				m.tsig = key
+
+			case "trusted_key":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("option 'trusted_key' expects at least one key"))
+				}
+				for _, arg := range args {
+					key, err := parseTrustedKey(arg)
+					if err != nil {
+						return nil, plugin.Error(ForwardPluginName, c.Errf("trusted_key is not a valid base64 Ed25519 public key: %s", arg))
+					}
+					trustedKeys = append(trustedKeys, key)
+				}
+
+			case "signature_max_age":
+				val, err := parseSingleArg(c)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, err)
+				}
+				maxAge, err := time.ParseDuration(val)
+				if err != nil {
+					return nil, plugin.Error(ForwardPluginName, c.Errf("invalid duration for signature_max_age: %s", val))
+				}
+				signatureMaxAge = maxAge
+
 			default:
 				return nil, plugin.Error(ForwardPluginName, c.Errf("unknown option: %s", c.Val()))
 			}
 		}
 	}
 
+	if m.maxTTL > 0 && m.minTTL > m.maxTTL {
+		return nil, plugin.Error(ForwardPluginName, c.Errf("min_ttl %d is larger than max_ttl %d", m.minTTL, m.maxTTL))
+	}
+
+	if len(trustedKeys) > 0 {
+		m.verifier = newPeerVerifier(trustedKeys, signatureMaxAge)
+	}
+
+	if m.rateLimiter != nil {
+		m.rateLimiter.ipv4Bits = rateLimitIPv4Bits
+		m.rateLimiter.ipv6Bits = rateLimitIPv6Bits
+		m.rateLimiter.action = rateLimitAction
+	}
+
 	var ifaces *[]net.Interface
-	if ifaceBindSubnet != nil {
-		foundIfaces, err := findIfaces(*ifaceBindSubnet)
+	if !ifaceSelector.empty() {
+		foundIfaces, err := ifaceSelector.interfaces(findIfaces)
 		if err != nil || len(foundIfaces) == 0 {
-			log.Errorf("Failed to find interface for '%s'\n", ifaceBindSubnet.String())
-			ifaces = &([]net.Interface{})
-		} else {
-			ifaces = &foundIfaces
+			log.Errorf("Failed to find interfaces for '%s'\n", &ifaceSelector)
+			foundIfaces = []net.Interface{}
 		}
+		ifaces = &foundIfaces
 	}
 
 	browser := browser.NewZeroconfBrowser("local.", mdnsType, ifaces)
 	browser.Log = log
+	browser.PartitionBy(TxtMesh)
+	if m.addrMode != IPv4Only {
+		browser.TrackInterfaceZones()
+	}
 	m.browser = browser
 
 	return &m, nil
 }
-
-// TODO: test parsing:
-//:53
-//127.0.0.1:1053
-//[::1]:1053
-//example.org (no explicit port; defaults to 53)
@@ -1,13 +1,19 @@
 package mdns
 
 import (
+	"crypto/ed25519"
+	"fmt"
 	"net"
+	"net/netip"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/miekg/dns"
 
 	"github.com/nbeirne/coredns-dnsmesh/mdns/browser"
 )
@@ -27,70 +33,139 @@ func TestQuerySetupSuccess(t *testing.T) {
 			name: "full configuration",
 			input: `dnsmesh_mdns example.com {
 			type sometype
+			mesh home
 			iface_bind_subnet 127.0.0.0/24
+			iface_bind_subnet 10.0.0.0/8 192.168.0.0/16
+			exclude_iface docker*
 			ignore_self true
 			filter .*
+			exclude guest
+			hostname ^node
+			require_txt role=prod
+			allow_cidr 10.0.0.0/8 192.168.1.0/24
+			deny_cidr 10.1.0.0/16
 			address_mode only_ipv6
 			addresses_per_host 1
 			timeout 5s
+			peer_timeout 1s
+			refresh_timeout 3s
 			attempts 3
 			worker_count 4
+			tsig mesh.key c2VjcmV0LXNlY3JldC1zZWNyZXQ= hmac-sha256
+			trusted_key 11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=
+			signature_max_age 10m
+			deny_qtypes AXFR ixfr
+			allow_qtypes A AAAA PTR ANY
+			minimal_any true
+			policy race
+			policy single PTR
+			min_ttl 5
+			max_ttl 300
+			peer_lifetime_ttl true
 		}`,
 			expectedPlugin: &MdnsForwardPlugin{
-				browser:      browser.NewZeroconfBrowser("local.", "sometype", &mockIfaces),
-				ignoreSelf:   true,
-				filter:       regexp.MustCompile(".*"),
-				addrMode:     IPv6Only,
-				addrsPerHost: 1,
-				Timeout:      5 * time.Second,
-				Zone:         "example.com",
-				Attempts:     3,
-				WorkerCount:  4,
+				browser:         browser.NewZeroconfBrowser("local.", "sometype", &mockIfaces),
+				ignoreSelf:      true,
+				filter:          regexp.MustCompile(".*"),
+				exclude:         regexp.MustCompile("guest"),
+				hostnameFilter:  regexp.MustCompile("^node"),
+				requireTxt:      map[string]string{"role": "prod"},
+				allowCidrs:      []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.0/24")},
+				denyCidrs:       []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+				addrMode:        IPv6Only,
+				addrsPerHost:    1,
+				Timeout:         5 * time.Second,
+				PeerTimeout:     time.Second,
+				refreshTimeout:  3 * time.Second,
+				Zone:            "example.com",
+				Mesh:            "home",
+				Attempts:        3,
+				WorkerCount:     4,
+				tsig:            &tsigKey{name: "mesh.key.", secret: "c2VjcmV0LXNlY3JldC1zZWNyZXQ=", algorithm: dns.HmacSHA256},
+				denyQtypes:      map[uint16]bool{dns.TypeAXFR: true, dns.TypeIXFR: true},
+				allowQtypes:     map[uint16]bool{dns.TypeA: true, dns.TypeAAAA: true, dns.TypePTR: true, dns.TypeANY: true},
+				minimalAny:      true,
+				policy:          RacePolicy,
+				qtypePolicies:   map[uint16]int{dns.TypePTR: SinglePeerPolicy},
+				minTTL:          5,
+				maxTTL:          300,
+				peerLifetimeTTL: true,
+				verifier: newPeerVerifier([]ed25519.PublicKey{
+					mustParseTrustedKey("11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="),
+				}, 10*time.Minute),
+			},
+		},
+		{
+			name: "repeated qtypes",
+			input: `dnsmesh_mdns example.com {
+			allow_qtypes A AAAA
+			allow_qtypes PTR
+			deny_qtypes AXFR
+			deny_qtypes IXFR
+		}`,
+			expectedPlugin: &MdnsForwardPlugin{
+				browser:        browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
+				addrMode:       DefaultAddrMode,
+				addrsPerHost:   DefaultAddrsPerHost,
+				Timeout:        DefaultTimeout,
+				PeerTimeout:    DefaultPeerTimeout,
+				refreshTimeout: DefaultRefreshTimeout,
+				Zone:           "example.com",
+				allowQtypes:    map[uint16]bool{dns.TypeA: true, dns.TypeAAAA: true, dns.TypePTR: true},
+				denyQtypes:     map[uint16]bool{dns.TypeAXFR: true, dns.TypeIXFR: true},
 			},
 		},
 		{
 			name:  "minimal config",
 			input: `dnsmesh_mdns example.com`,
 			expectedPlugin: &MdnsForwardPlugin{
-				browser:      browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
-				addrMode:     DefaultAddrMode,
-				addrsPerHost: DefaultAddrsPerHost,
-				Timeout:      DefaultTimeout,
-				Zone:         "example.com",
+				browser:        browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
+				addrMode:       DefaultAddrMode,
+				addrsPerHost:   DefaultAddrsPerHost,
+				Timeout:        DefaultTimeout,
+				PeerTimeout:    DefaultPeerTimeout,
+				refreshTimeout: DefaultRefreshTimeout,
+				Zone:           "example.com",
 			},
 		},
 		{
 			name:  "empty block",
 			input: `dnsmesh_mdns example.com {}`,
 			expectedPlugin: &MdnsForwardPlugin{
-				browser:      browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
-				addrMode:     DefaultAddrMode,
-				addrsPerHost: DefaultAddrsPerHost,
-				Timeout:      DefaultTimeout,
-				Zone:         "example.com",
+				browser:        browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
+				addrMode:       DefaultAddrMode,
+				addrsPerHost:   DefaultAddrsPerHost,
+				Timeout:        DefaultTimeout,
+				PeerTimeout:    DefaultPeerTimeout,
+				refreshTimeout: DefaultRefreshTimeout,
+				Zone:           "example.com",
 			},
 		},
 		{
 			name:  "custom timeout",
 			input: `dnsmesh_mdns example.com { timeout 4m }`,
 			expectedPlugin: &MdnsForwardPlugin{
-				browser:      browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
-				addrMode:     DefaultAddrMode,
-				addrsPerHost: DefaultAddrsPerHost,
-				Timeout:      4 * time.Minute,
-				Zone:         "example.com",
+				browser:        browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
+				addrMode:       DefaultAddrMode,
+				addrsPerHost:   DefaultAddrsPerHost,
+				Timeout:        4 * time.Minute,
+				PeerTimeout:    DefaultPeerTimeout,
+				refreshTimeout: DefaultRefreshTimeout,
+				Zone:           "example.com",
 			},
 		},
 		{
 			name:  "custom filter with space",
 			input: `dnsmesh_mdns example.com { filter ".*[A-Z] .*" }`,
 			expectedPlugin: &MdnsForwardPlugin{
-				browser:      browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
-				filter:       regexp.MustCompile(".*[A-Z] .*"),
-				addrMode:     DefaultAddrMode,
-				addrsPerHost: DefaultAddrsPerHost,
-				Timeout:      DefaultTimeout,
-				Zone:         "example.com",
+				browser:        browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
+				filter:         regexp.MustCompile(".*[A-Z] .*"),
+				addrMode:       DefaultAddrMode,
+				addrsPerHost:   DefaultAddrsPerHost,
+				Timeout:        DefaultTimeout,
+				PeerTimeout:    DefaultPeerTimeout,
+				refreshTimeout: DefaultRefreshTimeout,
+				Zone:           "example.com",
 			},
 		},
 		{
@@ -102,23 +177,27 @@ func TestQuerySetupSuccess(t *testing.T) {
 				address_mode prefer_ipv4
 			}`,
 			expectedPlugin: &MdnsForwardPlugin{
-				browser:      browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
-				addrMode:     PreferIPv4, // Last one wins
-				addrsPerHost: DefaultAddrsPerHost,
-				Timeout:      DefaultTimeout,
-				Zone:         "example.com",
+				browser:        browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
+				addrMode:       PreferIPv4, // Last one wins
+				addrsPerHost:   DefaultAddrsPerHost,
+				Timeout:        DefaultTimeout,
+				PeerTimeout:    DefaultPeerTimeout,
+				refreshTimeout: DefaultRefreshTimeout,
+				Zone:           "example.com",
 			},
 		},
 		{
 			name:  "explicitly disable ignore_self",
 			input: `dnsmesh_mdns example.com { ignore_self false }`,
 			expectedPlugin: &MdnsForwardPlugin{
-				browser:      browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
-				ignoreSelf:   false,
-				addrMode:     DefaultAddrMode,
-				addrsPerHost: DefaultAddrsPerHost,
-				Timeout:      DefaultTimeout,
-				Zone:         "example.com",
+				browser:        browser.NewZeroconfBrowser("local.", DefaultServiceType, nil),
+				ignoreSelf:     false,
+				addrMode:       DefaultAddrMode,
+				addrsPerHost:   DefaultAddrsPerHost,
+				Timeout:        DefaultTimeout,
+				PeerTimeout:    DefaultPeerTimeout,
+				refreshTimeout: DefaultRefreshTimeout,
+				Zone:           "example.com",
 			},
 		},
 	}
@@ -202,10 +281,127 @@ func TestQuerySetupFailure(t *testing.T) {
 			name:  "missing type value",
 			input: `dnsmesh_mdns example.com { type }`,
 		},
+		{
+			name:  "missing deny_qtypes value",
+			input: `dnsmesh_mdns example.com { deny_qtypes }`,
+		},
+		{
+			name:  "bad allow_qtypes value",
+			input: `dnsmesh_mdns example.com { allow_qtypes A NOTATYPE }`,
+		},
+		{
+			name:  "bad minimal_any value",
+			input: `dnsmesh_mdns example.com { minimal_any n }`,
+		},
+		{
+			name:  "missing policy value",
+			input: `dnsmesh_mdns example.com { policy }`,
+		},
+		{
+			name:  "bad policy value",
+			input: `dnsmesh_mdns example.com { policy fastest }`,
+		},
+		{
+			name:  "bad policy qtype",
+			input: `dnsmesh_mdns example.com { policy race NOTATYPE }`,
+		},
+		{
+			name:  "missing mesh value",
+			input: `dnsmesh_mdns example.com { mesh }`,
+		},
 		{
 			name:  "missing attempts value",
 			input: `dnsmesh_mdns example.com { attempts }`,
 		},
+		{
+			name:  "missing tsig values",
+			input: `dnsmesh_mdns example.com { tsig mesh.key }`,
+		},
+		{
+			name:  "bad tsig secret",
+			input: `dnsmesh_mdns example.com { tsig mesh.key not-base64! hmac-sha256 }`,
+		},
+		{
+			name:  "bad exclude regex",
+			input: `dnsmesh_mdns example.com { exclude "(" }`,
+		},
+		{
+			name:  "bad hostname regex",
+			input: `dnsmesh_mdns example.com { hostname "(" }`,
+		},
+		{
+			name:  "bad require_txt value",
+			input: `dnsmesh_mdns example.com { require_txt =value }`,
+		},
+		{
+			name:  "missing allow_cidr value",
+			input: `dnsmesh_mdns example.com { allow_cidr }`,
+		},
+		{
+			name:  "bad deny_cidr value",
+			input: `dnsmesh_mdns example.com { deny_cidr 10.0.0.1 }`,
+		},
+		{
+			name:  "missing trusted_key value",
+			input: `dnsmesh_mdns example.com { trusted_key }`,
+		},
+		{
+			name:  "bad trusted_key value",
+			input: `dnsmesh_mdns example.com { trusted_key c2VjcmV0 }`,
+		},
+		{
+			name:  "bad signature_max_age value",
+			input: `dnsmesh_mdns example.com { signature_max_age n }`,
+		},
+		{
+			name:  "bad tsig algorithm",
+			input: `dnsmesh_mdns example.com { tsig mesh.key c2VjcmV0 hmac-foo }`,
+		},
+		{
+			name: "bad client_rate_limit rate",
+			input: `dnsmesh_mdns example.com {
+				client_rate_limit fast
+			}`,
+		},
+		{
+			name: "bad global_rate_limit burst",
+			input: `dnsmesh_mdns example.com {
+				global_rate_limit 10 0
+			}`,
+		},
+		{
+			name: "bad client_rate_limit_prefix",
+			input: `dnsmesh_mdns example.com {
+				client_rate_limit_prefix 33 64
+			}`,
+		},
+		{
+			name:  "bad peer_timeout",
+			input: `dnsmesh_mdns example.com { peer_timeout 0s }`,
+		},
+		{
+			name:  "bad refresh_timeout",
+			input: `dnsmesh_mdns example.com { refresh_timeout soon }`,
+		},
+		{
+			name:  "bad max_ttl",
+			input: `dnsmesh_mdns example.com { max_ttl 1m }`,
+		},
+		{
+			name:  "bad peer_lifetime_ttl",
+			input: `dnsmesh_mdns example.com { peer_lifetime_ttl maybe }`,
+		},
+		{
+			name: "min_ttl above max_ttl",
+			input: `dnsmesh_mdns example.com {
+				min_ttl 600
+				max_ttl 60
+			}`,
+		},
+		{
+			name:  "bad rate_limit_action",
+			input: `dnsmesh_mdns example.com { rate_limit_action drop }`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -219,6 +415,45 @@ func TestQuerySetupFailure(t *testing.T) {
 	}
 }
 
+func TestQuerySetupRateLimit(t *testing.T) {
+	c := caddy.NewTestController("dns", `dnsmesh_mdns example.com {
+		client_rate_limit 5 20
+		client_rate_limit_prefix 24 56
+		global_rate_limit 0.5
+		rate_limit_action fallthrough
+	}`)
+	m, err := parseForwardOptions(c, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	l := m.rateLimiter
+	if l == nil {
+		t.Fatal("Expected a rate limiter")
+	}
+	if l.clientRate != 5 || l.clientBurst != 20 {
+		t.Errorf("Unexpected client limit: %v/%d", l.clientRate, l.clientBurst)
+	}
+	if l.ipv4Bits != 24 || l.ipv6Bits != 56 {
+		t.Errorf("Unexpected client prefixes: /%d /%d", l.ipv4Bits, l.ipv6Bits)
+	}
+	if l.global == nil || l.global.Limit() != 0.5 || l.global.Burst() != 1 {
+		t.Errorf("Unexpected global limit: %+v", l.global)
+	}
+	if l.action != FallthroughAction {
+		t.Errorf("Unexpected action: %d", l.action)
+	}
+
+	c = caddy.NewTestController("dns", `dnsmesh_mdns example.com`)
+	m, err = parseForwardOptions(c, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if m.rateLimiter != nil {
+		t.Error("Expected no rate limiter by default")
+	}
+}
+
 func assertQueryPluginsEqual(t *testing.T, expected, actual *MdnsForwardPlugin) {
 	t.Helper()
 
@@ -248,18 +483,102 @@ func assertQueryPluginsEqual(t *testing.T, expected, actual *MdnsForwardPlugin)
 	if expectedFilter != actualFilter {
 		t.Errorf("Filter mismatch: want %q, got %q", expectedFilter, actualFilter)
 	}
+	if regexString(expected.exclude) != regexString(actual.exclude) {
+		t.Errorf("Exclude mismatch: want %q, got %q", regexString(expected.exclude), regexString(actual.exclude))
+	}
+	if regexString(expected.hostnameFilter) != regexString(actual.hostnameFilter) {
+		t.Errorf("Hostname filter mismatch: want %q, got %q", regexString(expected.hostnameFilter), regexString(actual.hostnameFilter))
+	}
+
+	// Compare verifier settings, ignoring its runtime state
+	if (expected.verifier == nil) != (actual.verifier == nil) {
+		t.Errorf("Verifier mismatch: want %+v, got %+v", expected.verifier, actual.verifier)
+	} else if expected.verifier != nil {
+		if !reflect.DeepEqual(expected.verifier.trustedKeys, actual.verifier.trustedKeys) {
+			t.Errorf("Verifier trusted keys mismatch: want %v, got %v", expected.verifier.trustedKeys, actual.verifier.trustedKeys)
+		}
+		if expected.verifier.maxAge != actual.verifier.maxAge {
+			t.Errorf("Verifier max age mismatch: want %v, got %v", expected.verifier.maxAge, actual.verifier.maxAge)
+		}
+	}
 
 	// Nil out the fields we've already checked for the final DeepEqual
 	expected.browser = nil
 	actual.browser = nil
 	expected.filter = nil
 	actual.filter = nil
+	expected.verifier = nil
+	actual.verifier = nil
+	expected.exclude = nil
+	actual.exclude = nil
+	expected.hostnameFilter = nil
+	actual.hostnameFilter = nil
 
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf("Plugin mismatch:\n- Want: %+v\n- Got:  %+v", expected, actual)
 	}
 }
 
+func TestProxySetup(t *testing.T) {
+	c := caddy.NewTestController("dns", `dnsmesh_mdns_proxy Home.Example {
+		type _ipp._tcp _airplay._tcp.
+		type _ipp._tcp
+		ttl 5
+	}`)
+	p, err := parseProxyOptions(c, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if p.Zone != "home.example." {
+		t.Errorf("Expected zone home.example., got %s", p.Zone)
+	}
+	if expected := []string{"_ipp._tcp", "_airplay._tcp"}; !reflect.DeepEqual(p.types, expected) {
+		t.Errorf("Expected types %v, got %v", expected, p.types)
+	}
+	if len(p.browsers) != 2 {
+		t.Errorf("Expected a browser per type, got %d", len(p.browsers))
+	}
+	if p.ttl != 5 {
+		t.Errorf("Expected ttl 5, got %d", p.ttl)
+	}
+}
+
+func TestProxySetupFailure(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{name: "no zone", input: `dnsmesh_mdns_proxy { type _ipp._tcp }`},
+		{name: "no type", input: `dnsmesh_mdns_proxy home.example`},
+		{name: "empty type", input: `dnsmesh_mdns_proxy home.example { type }`},
+		{name: "bad type", input: `dnsmesh_mdns_proxy home.example { type ipp.tcp }`},
+		{name: "bad protocol", input: `dnsmesh_mdns_proxy home.example { type _ipp._sctp }`},
+		{
+			name: "bad ttl",
+			input: `dnsmesh_mdns_proxy home.example {
+			type _ipp._tcp
+			ttl 0
+		}`,
+		},
+		{
+			name: "unknown option",
+			input: `dnsmesh_mdns_proxy home.example {
+			type _ipp._tcp
+			filter .*
+		}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", tc.input)
+			if _, err := parseProxyOptions(c, nil); err == nil {
+				t.Fatal("Expected an error, but got none")
+			}
+		})
+	}
+}
+
 func TestAdvertiseSetupSuccess(t *testing.T) {
 	testCases := []struct {
 		name  string
@@ -272,7 +591,47 @@ func TestAdvertiseSetupSuccess(t *testing.T) {
 			type  testtype
 			port 100
 			ttl 100
-			iface_bind_subnet 127.0.0.0/24
+			iface_bind_subnet 127.0.0.0/24 10.0.0.0/8
+			iface wg*
+			exclude_iface docker*
+			tsig mesh.key c2VjcmV0LXNlY3JldC1zZWNyZXQ= hmac-sha256
+			node_id node-1
+			mesh home
+			signing_key nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A=
+			subnets 192.168.2.0/24 fd00::/64
+			txt role prod
+			txt description "living room"
+		}`,
+		},
+		{
+			name: "auto subnets",
+			input: `dnsmesh_mdns_advertise {
+			subnets auto
+		}`,
+		},
+		{
+			name: "dns health check",
+			input: `dnsmesh_mdns_advertise {
+			health_check dns canary.example.com AAAA
+			health_interval 5s
+			health_threshold 2 4
+		}`,
+		},
+		{
+			name: "http health check",
+			input: `dnsmesh_mdns_advertise {
+			health_check http http://localhost:8181/ready
+		}`,
+		},
+		{name: "drain", input: `dnsmesh_mdns_advertise { drain 5s }`},
+		{
+			name: "hosts",
+			input: `dnsmesh_mdns_advertise {
+			hosts {
+				192.168.1.10 nas nas-backup.local
+				fd00::10 nas
+			}
+			port 1053
 		}`,
 		},
 		{name: "minimal config", input: `dnsmesh_mdns_advertise`},
@@ -296,8 +655,131 @@ func TestAdvertiseSetupFailure(t *testing.T) {
 		input string
 	}{
 		{name: "bad subnet", input: `dnsmesh_mdns_advertise { iface_bind_subnet 127.0.0.1 }`},
+		{
+			name: "bad iface pattern",
+			input: `dnsmesh_mdns_advertise {
+			iface eth[
+		}`,
+		},
+		{
+			name: "missing exclude_iface",
+			input: `dnsmesh_mdns_advertise {
+			exclude_iface
+		}`,
+		},
 		{name: "bad port", input: `dnsmesh_mdns_advertise { port m }`},
+		{
+			name: "bad type port",
+			input: `dnsmesh_mdns_advertise {
+			type _dns._tcp 0
+		}`,
+		},
+		{
+			name: "duplicate type",
+			input: `dnsmesh_mdns_advertise {
+			type _dns._udp
+			type _DNS._udp 5353
+		}`,
+		},
+		{
+			name: "type with extra arguments",
+			input: `dnsmesh_mdns_advertise {
+			type _dns._udp 53 54
+		}`,
+		},
 		{name: "bad ttl", input: `dnsmesh_mdns_advertise { ttl 1m }`},
+		{name: "bad tsig", input: `dnsmesh_mdns_advertise { tsig mesh.key c2VjcmV0 }`},
+		{name: "bad signing_key", input: `dnsmesh_mdns_advertise { signing_key c2VjcmV0 }`},
+		{name: "missing node_id", input: `dnsmesh_mdns_advertise { node_id }`},
+		{name: "missing subnets", input: `dnsmesh_mdns_advertise { subnets }`},
+		{
+			name: "txt without value",
+			input: `dnsmesh_mdns_advertise {
+			txt role
+		}`,
+		},
+		{
+			name: "reserved txt key",
+			input: `dnsmesh_mdns_advertise {
+			txt node_id other
+		}`,
+		},
+		{
+			name: "duplicate txt key",
+			input: `dnsmesh_mdns_advertise {
+			txt role prod
+			txt ROLE dev
+		}`,
+		},
+		{name: "txt record too large", input: largeTxtConfig(7, 200)},
+		{name: "bad subnets", input: `dnsmesh_mdns_advertise { subnets 192.168.2.0/24 auto }`},
+		{
+			name: "health_check without kind",
+			input: `dnsmesh_mdns_advertise {
+			health_check
+		}`,
+		},
+		{
+			name: "health_check with unknown kind",
+			input: `dnsmesh_mdns_advertise {
+			health_check ping 127.0.0.1
+		}`,
+		},
+		{
+			name: "health_check with bad type",
+			input: `dnsmesh_mdns_advertise {
+			health_check dns canary.example.com BOGUS
+		}`,
+		},
+		{
+			name: "health_check with bad url",
+			input: `dnsmesh_mdns_advertise {
+			health_check http localhost:8181/ready
+		}`,
+		},
+		{name: "bad health_interval", input: `dnsmesh_mdns_advertise { health_interval 0s }`},
+		{
+			name: "health_threshold with one count",
+			input: `dnsmesh_mdns_advertise {
+			health_threshold 3
+		}`,
+		},
+		{
+			name: "health_threshold with zero count",
+			input: `dnsmesh_mdns_advertise {
+			health_threshold 0 2
+		}`,
+		},
+		{name: "drain without duration", input: `dnsmesh_mdns_advertise { drain }`},
+		{name: "bad drain", input: `dnsmesh_mdns_advertise { drain 0s }`},
+		{
+			name: "hosts without block",
+			input: `dnsmesh_mdns_advertise {
+			hosts 192.168.1.10 nas
+		}`,
+		},
+		{
+			name: "hosts with bad address",
+			input: `dnsmesh_mdns_advertise {
+			hosts {
+				192.168.1 nas
+			}
+		}`,
+		},
+		{
+			name: "hosts without name",
+			input: `dnsmesh_mdns_advertise {
+			hosts {
+				192.168.1.10
+			}
+		}`,
+		},
+		{
+			name: "hosts without closing brace",
+			input: `dnsmesh_mdns_advertise {
+			hosts {
+				192.168.1.10 nas`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -310,3 +792,158 @@ func TestAdvertiseSetupFailure(t *testing.T) {
 		})
 	}
 }
+
+func TestAdvertiseSetupTsigOrder(t *testing.T) {
+	directives := dnsserver.Directives
+	defer func() { dnsserver.Directives = directives }()
+	dnsserver.Directives = []string{"log", "cache", AdvertisePluginName}
+
+	c := caddy.NewTestController("dns", `dnsmesh_mdns_advertise {
+		tsig mesh.key c2VjcmV0LXNlY3JldC1zZWNyZXQ= hmac-sha256
+	}`)
+	if err := setupAdvertise(c); err == nil {
+		t.Fatal("Expected an error for a plugin answering before the TSIG handler, but got none")
+	}
+}
+
+func TestAdvertiseSetupServices(t *testing.T) {
+	c := caddy.NewTestController("dns", `dnsmesh_mdns_advertise {
+		type _services._udp
+		type _services._tcp 5353
+		port 1053
+	}`)
+	before := keysOf(advertisers)
+	if err := setupAdvertise(c); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	for _, key := range newKeys(advertisers, before) {
+		defer advertisers.release(key)
+	}
+
+	advertiser := AdvertiserFor(dnsserver.GetConfig(c))
+	expected := []advertisedService{
+		{service: "_services._udp", port: 1053},
+		{service: "_services._tcp", port: 5353},
+	}
+	if !reflect.DeepEqual(advertiser.services, expected) {
+		t.Errorf("Expected %v, got %v", expected, advertiser.services)
+	}
+}
+
+func TestAdvertiseSetupHosts(t *testing.T) {
+	c := caddy.NewTestController("dns", `dnsmesh_mdns_advertise {
+		type _hosts._udp
+		hosts {
+			192.168.1.10 nas NAS-backup.local
+			fd00::10 nas
+		}
+		port 1053
+	}`)
+	before := keysOf(advertisers)
+	if err := setupAdvertise(c); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	for _, key := range newKeys(advertisers, before) {
+		defer advertisers.release(key)
+	}
+
+	advertiser := AdvertiserFor(dnsserver.GetConfig(c))
+	expected := map[string][]net.IP{
+		"nas.local.":        {net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")},
+		"nas-backup.local.": {net.ParseIP("192.168.1.10")},
+	}
+	if !reflect.DeepEqual(advertiser.hostRecords, expected) {
+		t.Errorf("Expected %v, got %v", expected, advertiser.hostRecords)
+	}
+	if advertiser.services[0].port != 1053 {
+		t.Errorf("Expected the options after hosts to apply, got port %d", advertiser.services[0].port)
+	}
+}
+
+func TestAdvertiseSetupPort(t *testing.T) {
+	testCases := []struct {
+		name     string
+		keys     []string
+		input    string
+		expected int
+	}{
+		{name: "from the server block", keys: []string{"tls://example.org", "example.org:1053"}, input: `dnsmesh_mdns_advertise {
+			type _port1._udp
+		}`, expected: 1053},
+		{name: "port option", keys: []string{"tls://example.org"}, input: `dnsmesh_mdns_advertise {
+			type _port2._udp
+			port 5300
+		}`, expected: 5300},
+		{name: "no plain dns key", keys: []string{"tls://example.org"}, input: `dnsmesh_mdns_advertise {
+			type _port3._udp
+		}`},
+		{name: "every type with a port", keys: []string{"tls://example.org"}, input: `dnsmesh_mdns_advertise {
+			type _port4._tcp 853
+		}`, expected: 853},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", tc.input)
+			c.ServerBlockKeys = tc.keys
+			before := keysOf(advertisers)
+			err := setupAdvertise(c)
+			for _, key := range newKeys(advertisers, before) {
+				defer advertisers.release(key)
+			}
+			if tc.expected == 0 {
+				if err == nil {
+					t.Fatal("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if port := AdvertiserFor(dnsserver.GetConfig(c)).services[0].port; port != tc.expected {
+				t.Errorf("Expected port %d, got %d", tc.expected, port)
+			}
+		})
+	}
+}
+
+func TestAdvertiseSetupLongServerBlock(t *testing.T) {
+	keys := []string{}
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("zone%02d.home.example:53", i))
+	}
+	c := caddy.NewTestController("dns", `dnsmesh_mdns_advertise`)
+	c.ServerBlockKeys = keys
+	before := keysOf(advertisers)
+	err := setupAdvertise(c)
+	for _, key := range newKeys(advertisers, before) {
+		advertisers.release(key)
+	}
+	if err == nil || !strings.Contains(err.Error(), TxtZones) {
+		t.Fatalf("Expected an error for a zones entry over 255 bytes, got %v", err)
+	}
+}
+
+// largeTxtConfig returns an advertise config with count txt options of about size bytes each.
+func largeTxtConfig(count, size int) string {
+	config := "dnsmesh_mdns_advertise {\n"
+	for i := 0; i < count; i++ {
+		config += fmt.Sprintf("txt key%d %s\n", i, strings.Repeat("v", size))
+	}
+	return config + "}"
+}
+
+func mustParseTrustedKey(val string) ed25519.PublicKey {
+	key, err := parseTrustedKey(val)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func regexString(re *regexp.Regexp) string {
+	if re == nil {
+		return ""
+	}
+	return re.String()
+}
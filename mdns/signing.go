@@ -0,0 +1,161 @@
+package mdns
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// MaxSignatureSkew is how far in the future an advertisement timestamp may be
+// before it is rejected, to tolerate clock differences between peers.
+const MaxSignatureSkew = time.Minute
+
+var (
+	errUnsignedAdvertisement = errors.New("advertisement is not signed")
+	errBadSignature          = errors.New("advertisement signature does not match a trusted key")
+	errStaleSignature        = errors.New("advertisement signature is stale")
+	errReplayedSignature     = errors.New("advertisement signature is older than one already seen")
+)
+
+// advertisementPayload is the message covered by an advertisement signature.
+func advertisementPayload(instance string, port int, nodeID string, timestamp int64) []byte {
+	return []byte(fmt.Sprintf("%s\n%d\n%s\n%d", instance, port, nodeID, timestamp))
+}
+
+// signAdvertisement returns the TXT entries which sign an advertisement.
+func signAdvertisement(key ed25519.PrivateKey, instance string, port int, nodeID string, timestamp int64) []string {
+	sig := ed25519.Sign(key, advertisementPayload(instance, port, nodeID, timestamp))
+	return []string{
+		txtEntry(TxtTimestamp, strconv.FormatInt(timestamp, 10)),
+		txtEntry(TxtSignature, base64.StdEncoding.EncodeToString(sig)),
+	}
+}
+
+// parseSigningKey parses a base64 Ed25519 private key, either as a 32 byte
+// seed or as a full 64 byte private key.
+func parseSigningKey(val string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, err
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, fmt.Errorf("expected %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(raw))
+}
+
+// parseTrustedKey parses a base64 Ed25519 public key.
+func parseTrustedKey(val string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// peerVerifier accepts only peers whose advertisement is signed by a trusted
+// key, with a recent timestamp that is not older than one already accepted
+// for the same instance of the same node.
+type peerVerifier struct {
+	trustedKeys []ed25519.PublicKey
+	maxAge      time.Duration
+
+	mutex  sync.Mutex
+	newest map[string]int64 // instance and node ID -> newest accepted timestamp
+
+	now func() time.Time
+}
+
+func newPeerVerifier(trustedKeys []ed25519.PublicKey, maxAge time.Duration) *peerVerifier {
+	return &peerVerifier{
+		trustedKeys: trustedKeys,
+		maxAge:      maxAge,
+		newest:      make(map[string]int64),
+		now:         time.Now,
+	}
+}
+
+func (v *peerVerifier) verify(entry *zeroconf.ServiceEntry) error {
+	nodeID, _ := txtValue(entry.Text, TxtNodeID)
+	tsStr, hasTs := txtValue(entry.Text, TxtTimestamp)
+	sigStr, hasSig := txtValue(entry.Text, TxtSignature)
+	if !hasTs || !hasSig {
+		return errUnsignedAdvertisement
+	}
+
+	timestamp, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return errUnsignedAdvertisement
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigStr)
+	if err != nil {
+		return errBadSignature
+	}
+
+	// The advertiser signs the instance name as configured, while browsed
+	// names are in presentation format, e.g. "meshdns-node\ \(2\)".
+	instance := unescapeLabel(entry.Instance)
+	payload := advertisementPayload(instance, entry.Port, nodeID, timestamp)
+	trusted := false
+	for _, key := range v.trustedKeys {
+		if ed25519.Verify(key, payload, sig) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return errBadSignature
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	now := v.now()
+	if now.Sub(signedAt) > v.maxAge || signedAt.Sub(now) > MaxSignatureSkew {
+		return errStaleSignature
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	// Node IDs default to the short hostname, so nodes sharing a hostname
+	// are told apart by their instance names.
+	key := instance + "\n" + nodeID
+	if timestamp < v.newest[key] {
+		return errReplayedSignature
+	}
+	v.newest[key] = timestamp
+	return nil
+}
+
+// unescapeLabel reverses the presentation format escapes of a DNS label,
+// "\X" and "\DDD".
+func unescapeLabel(label string) string {
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 >= len(label) {
+			b.WriteByte(label[i])
+			continue
+		}
+		if i+3 < len(label) {
+			if v, err := strconv.Atoi(label[i+1 : i+4]); err == nil && v < 256 {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(label[i+1])
+		i++
+	}
+	return b.String()
+}
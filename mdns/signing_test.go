@@ -0,0 +1,86 @@
+package mdns
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+func TestPeerVerifier(t *testing.T) {
+	trustedPub, trustedKey, _ := ed25519.GenerateKey(nil)
+	_, untrustedKey, _ := ed25519.GenerateKey(nil)
+	now := time.Unix(1700000000, 0)
+
+	// signedInstance returns a browsed entry, whose instance name is in
+	// presentation format, signed for the instance name as configured.
+	signedInstance := func(key ed25519.PrivateKey, instance, browsed string, signedAt time.Time) *zeroconf.ServiceEntry {
+		entry := &zeroconf.ServiceEntry{
+			ServiceRecord: zeroconf.ServiceRecord{Instance: browsed},
+			Port:          53,
+		}
+		entry.Text = append([]string{txtEntry(TxtNodeID, "node")},
+			signAdvertisement(key, instance, entry.Port, "node", signedAt.Unix())...)
+		return entry
+	}
+	signedEntry := func(key ed25519.PrivateKey, signedAt time.Time) *zeroconf.ServiceEntry {
+		return signedInstance(key, "meshdns-node", "meshdns-node", signedAt)
+	}
+
+	tamperedEntry := signedEntry(trustedKey, now)
+	tamperedEntry.Port = 5353
+
+	testCases := []struct {
+		name     string
+		entries  []*zeroconf.ServiceEntry
+		expected error
+	}{
+		{name: "valid", entries: []*zeroconf.ServiceEntry{signedEntry(trustedKey, now)}},
+		{
+			name:     "unsigned",
+			entries:  []*zeroconf.ServiceEntry{{ServiceRecord: zeroconf.ServiceRecord{Instance: "meshdns-node"}, Port: 53}},
+			expected: errUnsignedAdvertisement,
+		},
+		{name: "untrusted key", entries: []*zeroconf.ServiceEntry{signedEntry(untrustedKey, now)}, expected: errBadSignature},
+		{name: "tampered port", entries: []*zeroconf.ServiceEntry{tamperedEntry}, expected: errBadSignature},
+		{name: "stale", entries: []*zeroconf.ServiceEntry{signedEntry(trustedKey, now.Add(-time.Hour))}, expected: errStaleSignature},
+		{name: "future", entries: []*zeroconf.ServiceEntry{signedEntry(trustedKey, now.Add(time.Hour))}, expected: errStaleSignature},
+		{
+			name:    "refreshed",
+			entries: []*zeroconf.ServiceEntry{signedEntry(trustedKey, now.Add(-time.Minute)), signedEntry(trustedKey, now)},
+		},
+		{
+			name:    "escaped instance name",
+			entries: []*zeroconf.ServiceEntry{signedInstance(trustedKey, "My Node (2)", `My\ Node\ \(2\)`, now)},
+		},
+		{
+			name: "instances sharing a node ID",
+			entries: []*zeroconf.ServiceEntry{
+				signedInstance(trustedKey, "meshdns-node", "meshdns-node", now),
+				signedInstance(trustedKey, "meshdns-node (2)", `meshdns-node\ \(2\)`, now.Add(-time.Minute)),
+			},
+		},
+		{
+			name:     "replayed",
+			entries:  []*zeroconf.ServiceEntry{signedEntry(trustedKey, now), signedEntry(trustedKey, now.Add(-time.Minute))},
+			expected: errReplayedSignature,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier := newPeerVerifier([]ed25519.PublicKey{trustedPub}, DefaultSignatureMaxAge)
+			verifier.now = func() time.Time { return now }
+
+			var err error
+			for _, entry := range tc.entries {
+				err = verifier.verify(entry)
+			}
+			if !errors.Is(err, tc.expected) {
+				t.Errorf("Unexpected verification result: got %v, want %v", err, tc.expected)
+			}
+		})
+	}
+}
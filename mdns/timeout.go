@@ -0,0 +1,23 @@
+package mdns
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/networkservicemesh/fanout"
+)
+
+// timeoutClient is a fanout.Client which bounds the requests to a single peer.
+type timeoutClient struct {
+	fanout.Client
+	timeout time.Duration
+}
+
+// Request implements fanout.Client.
+func (c *timeoutClient) Request(ctx context.Context, r *request.Request) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.Request(ctx, r)
+}
@@ -0,0 +1,64 @@
+package mdns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// deadlineFanout records the deadline of the context it was called with.
+type deadlineFanout struct {
+	deadline time.Time
+}
+
+func (f *deadlineFanout) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	f.deadline, _ = ctx.Deadline()
+	w.WriteMsg(new(dns.Msg).SetReply(r))
+	return dns.RcodeSuccess, nil
+}
+
+func TestServeDNSDeadline(t *testing.T) {
+	testCases := []struct {
+		name           string
+		timeout        time.Duration
+		clientDeadline time.Duration
+		expected       time.Duration
+	}{
+		{name: "total timeout", timeout: 2 * time.Second, expected: 2 * time.Second},
+		{name: "client deadline", timeout: time.Minute, clientDeadline: time.Second, expected: time.Second},
+		{name: "total timeout before client deadline", timeout: time.Second, clientDeadline: time.Minute, expected: time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &deadlineFanout{}
+			m := MdnsForwardPlugin{
+				Zone:             "example.com.",
+				Timeout:          tc.timeout,
+				createFanoutFunc: func(p *MdnsForwardPlugin) fanoutHandler { return f },
+			}
+
+			ctx := context.Background()
+			if tc.clientDeadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.clientDeadline)
+				defer cancel()
+			}
+
+			start := time.Now()
+			req := new(dns.Msg).SetQuestion("host.example.com.", dns.TypeA)
+			if _, err := m.ServeDNS(ctx, dnstest.NewRecorder(&test.ResponseWriter{}), req); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+
+			remaining := f.deadline.Sub(start)
+			if remaining > tc.expected+time.Second/2 || remaining < tc.expected-time.Second/2 {
+				t.Errorf("Unexpected deadline: got %v, want about %v", remaining, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package mdns
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/networkservicemesh/fanout"
+)
+
+// PeerBufferSize is the EDNS buffer size advertised to peers. It avoids IP
+// fragmentation as recommended by DNS flag day 2020. Replies are truncated to
+// the client's own buffer size by the server before they are sent.
+const PeerBufferSize = 1232
+
+// truncationClient is a fanout.Client which queries a peer over UDP with a
+// large enough EDNS buffer, and asks the same peer again over TCP when the
+// UDP response is truncated.
+type truncationClient struct {
+	fanout.Client // UDP client
+	tcp           fanout.Client
+}
+
+// Request implements fanout.Client.
+func (c *truncationClient) Request(ctx context.Context, r *request.Request) (*dns.Msg, error) {
+	// The request is shared between all fanout workers, so modify a copy.
+	req := r.Req.Copy()
+	clientEdns := req.IsEdns0() != nil
+	if opt := req.IsEdns0(); opt != nil {
+		opt.SetUDPSize(max(opt.UDPSize(), PeerBufferSize))
+	} else {
+		req.SetEdns0(PeerBufferSize, false)
+	}
+	state := &request.Request{W: r.W, Req: req}
+
+	ret, err := c.Client.Request(ctx, state)
+	if err == nil && ret.Truncated {
+		log.Debugf("Response from %s for '%s' is truncated, retrying over TCP", c.Endpoint(), state.Name())
+		ret, err = c.tcp.Request(ctx, state)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't hand an OPT record to a client which did not ask for EDNS.
+	if !clientEdns {
+		extra := ret.Extra[:0]
+		for _, rr := range ret.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		ret.Extra = extra
+	}
+	return ret, nil
+}
@@ -0,0 +1,84 @@
+package mdns
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// recordingClient answers with a fixed response and records the requests it saw.
+type recordingClient struct {
+	net      string
+	response func(r *dns.Msg) *dns.Msg
+	requests []*dns.Msg
+}
+
+func (c *recordingClient) Request(ctx context.Context, r *request.Request) (*dns.Msg, error) {
+	c.requests = append(c.requests, r.Req)
+	return c.response(r.Req), nil
+}
+func (c *recordingClient) Endpoint() string         { return "127.0.0.1:53" }
+func (c *recordingClient) Net() string              { return c.net }
+func (c *recordingClient) SetTLSConfig(*tls.Config) {}
+
+func TestTruncationClient(t *testing.T) {
+	reply := func(truncated bool) func(r *dns.Msg) *dns.Msg {
+		return func(r *dns.Msg) *dns.Msg {
+			resp := new(dns.Msg).SetReply(r)
+			resp.Truncated = truncated
+			resp.SetEdns0(PeerBufferSize, false)
+			return resp
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		truncated   bool
+		clientEdns  uint16
+		expectedTCP int
+	}{
+		{name: "not truncated", truncated: false},
+		{name: "truncated", truncated: true, expectedTCP: 1},
+		{name: "client edns", truncated: false, clientEdns: 4096},
+		{name: "small client edns", truncated: true, clientEdns: 512, expectedTCP: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			udp := &recordingClient{net: "udp", response: reply(tc.truncated)}
+			tcp := &recordingClient{net: "tcp", response: reply(false)}
+			c := &truncationClient{Client: udp, tcp: tcp}
+
+			req := new(dns.Msg).SetQuestion("example.com.", dns.TypeAAAA)
+			if tc.clientEdns > 0 {
+				req.SetEdns0(tc.clientEdns, false)
+			}
+			ret, err := c.Request(context.Background(), &request.Request{W: &test.ResponseWriter{}, Req: req})
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+
+			if len(tcp.requests) != tc.expectedTCP {
+				t.Errorf("Unexpected TCP requests: got %d, want %d", len(tcp.requests), tc.expectedTCP)
+			}
+			if ret.Truncated {
+				t.Error("Expected a complete response")
+			}
+
+			opt := udp.requests[0].IsEdns0()
+			if opt == nil || opt.UDPSize() != max(tc.clientEdns, PeerBufferSize) {
+				t.Errorf("Unexpected EDNS buffer size sent to the peer: %v", opt)
+			}
+			if opt := req.IsEdns0(); (opt != nil) != (tc.clientEdns > 0) || (opt != nil && opt.UDPSize() != tc.clientEdns) {
+				t.Errorf("Expected the client's request to be left alone, got %v", opt)
+			}
+			if (ret.IsEdns0() != nil) != (tc.clientEdns > 0) {
+				t.Errorf("Expected an OPT record in the response only when the client sent one, got %v", ret.IsEdns0())
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package mdns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/networkservicemesh/fanout"
+)
+
+// TsigFudge is the permitted clock skew, in seconds, for signed mesh queries.
+const TsigFudge = 300
+
+var errUnsignedResponse = errors.New("peer response is not TSIG signed")
+
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// tsigKey is a TSIG key shared by all peers of a mesh.
+type tsigKey struct {
+	name      string
+	secret    string
+	algorithm string
+}
+
+// parseTsigKey parses the arguments of a `tsig <keyname> <secret> <alg>` option.
+func parseTsigKey(c *caddy.Controller) (*tsigKey, error) {
+	args := c.RemainingArgs()
+	if len(args) != 3 {
+		return nil, c.Errf("option 'tsig' expects a key name, a secret and an algorithm")
+	}
+
+	name := plugin.Name(args[0]).Normalize()
+	if _, err := base64.StdEncoding.DecodeString(args[1]); err != nil {
+		return nil, c.Errf("tsig secret is not valid base64: %s", args[1])
+	}
+	algorithm, ok := tsigAlgorithms[strings.TrimSuffix(strings.ToLower(args[2]), ".")]
+	if !ok {
+		return nil, c.Errf("unknown tsig algorithm: %s", args[2])
+	}
+
+	return &tsigKey{name: name, secret: args[1], algorithm: algorithm}, nil
+}
+
+// tsigPrecedingDirectives are the directives of plugin.cfg which may come
+// before dnsmesh_mdns_advertise when it verifies signed queries. They set up
+// the server or observe queries, but never answer one themselves.
+var tsigPrecedingDirectives = map[string]bool{
+	"root": true, "metadata": true, "geoip": true, "cancel": true, "tls": true, "quic": true,
+	"timeouts": true, "multisocket": true, "reload": true, "nsid": true, "bufsize": true,
+	"bind": true, "debug": true, "trace": true, "ready": true, "health": true, "pprof": true,
+	"prometheus": true, "errors": true, "log": true, "dnstap": true,
+}
+
+// checkTsigOrder returns an error when a directive which may answer queries
+// comes before dnsmesh_mdns_advertise in directives. The TSIG handler sits at
+// the position of the directive in the plugin chain, so such a plugin would
+// answer signed queries without a signature.
+func checkTsigOrder(directives []string) error {
+	i := slices.Index(directives, AdvertisePluginName)
+	if i < 0 {
+		return nil
+	}
+	for _, d := range directives[:i] {
+		if !tsigPrecedingDirectives[d] {
+			return fmt.Errorf("option 'tsig' requires %s to come before %s in plugin.cfg", AdvertisePluginName, d)
+		}
+	}
+	return nil
+}
+
+func (k *tsigKey) secrets() map[string]string {
+	return map[string]string{k.name: k.secret}
+}
+
+// stripTsig removes a trailing TSIG record from the message, if present.
+func stripTsig(m *dns.Msg) {
+	if m.IsTsig() != nil {
+		m.Extra = m.Extra[:len(m.Extra)-1]
+	}
+}
+
+// tsigClient is a fanout.Client which signs queries to a peer and only
+// accepts responses carrying a valid signature for the same key.
+type tsigClient struct {
+	addr string
+	net  string
+	key  *tsigKey
+}
+
+func newTsigClient(addr, net string, key *tsigKey) fanout.Client {
+	return &tsigClient{addr: addr, net: net, key: key}
+}
+
+// Request implements fanout.Client.
+func (c *tsigClient) Request(ctx context.Context, r *request.Request) (*dns.Msg, error) {
+	// The request is shared between all fanout workers, so sign a copy.
+	req := r.Req.Copy()
+	stripTsig(req)
+	req.SetTsig(c.key.name, c.key.algorithm, TsigFudge, time.Now().Unix())
+
+	client := &dns.Client{
+		Net:        c.net,
+		UDPSize:    uint16(r.Size()),
+		TsigSecret: c.key.secrets(),
+	}
+	ret, _, err := client.ExchangeContext(ctx, req, c.addr)
+	if err != nil {
+		// A response with a bad signature is reported by the exchange itself.
+		log.Warningf("Dropping response from %s: %v", c.addr, err)
+		return nil, err
+	}
+	if ret.IsTsig() == nil {
+		log.Warningf("Dropping response from %s: %v", c.addr, errUnsignedResponse)
+		return nil, errUnsignedResponse
+	}
+
+	stripTsig(ret)
+	return ret, nil
+}
+
+// Endpoint implements fanout.Client.
+func (c *tsigClient) Endpoint() string { return c.addr }
+
+// Net implements fanout.Client.
+func (c *tsigClient) Net() string { return c.net }
+
+// SetTLSConfig implements fanout.Client. TLS is not supported between mesh peers.
+func (c *tsigClient) SetTLSConfig(*tls.Config) {}
+
+// tsigHandler verifies signed queries from mesh peers and signs the responses.
+// Unsigned queries are passed through untouched so regular clients of the
+// server keep working; queries with a bad signature are answered with NOTAUTH.
+// It has to come before every plugin which answers queries, see
+// checkTsigOrder.
+type tsigHandler struct {
+	Next plugin.Handler
+}
+
+// Name implements the Handler interface.
+func (t *tsigHandler) Name() string { return AdvertisePluginName }
+
+// ServeDNS implements the Handler interface.
+func (t *tsigHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	tsigRR := r.IsTsig()
+	if tsigRR == nil {
+		return plugin.NextOrFailure(t.Name(), t.Next, ctx, w, r)
+	}
+
+	w = &tsigResponseWriter{ResponseWriter: w, req: r, reqTsig: tsigRR}
+
+	if err := w.TsigStatus(); err != nil {
+		log.Warningf("Rejecting query for '%s' with a bad TSIG signature: %v", r.Question[0].Name, err)
+		resp := new(dns.Msg).SetRcode(r, dns.RcodeNotAuth)
+		switch err {
+		case dns.ErrSecret:
+			writeUnsignedTsigError(w, resp, tsigRR, dns.RcodeBadKey)
+		case dns.ErrTime:
+			tsigRR.Error = dns.RcodeBadTime
+			w.WriteMsg(resp)
+		default:
+			writeUnsignedTsigError(w, resp, tsigRR, dns.RcodeBadSig)
+		}
+		return dns.RcodeSuccess, nil
+	}
+
+	// The rest of the plugin chain should not see the TSIG record.
+	stripTsig(r)
+
+	rcode, err := plugin.NextOrFailure(t.Name(), t.Next, ctx, w, r)
+	if !plugin.ClientWrite(rcode) {
+		resp := new(dns.Msg).SetRcode(r, rcode)
+		w.WriteMsg(resp)
+		return dns.RcodeSuccess, err
+	}
+	return rcode, err
+}
+
+// writeUnsignedTsigError writes resp with a TSIG record carrying the error and
+// no MAC. The server would sign it otherwise, but a BADKEY or BADSIG error
+// must not be signed, see RFC 8945 section 5.3.2.
+func writeUnsignedTsigError(w dns.ResponseWriter, resp *dns.Msg, reqTsig *dns.TSIG, tsigErr uint16) {
+	resp.Extra = append(resp.Extra, &dns.TSIG{
+		Hdr:        dns.RR_Header{Name: reqTsig.Hdr.Name, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+		Algorithm:  reqTsig.Algorithm,
+		TimeSigned: uint64(time.Now().Unix()),
+		Fudge:      TsigFudge,
+		OrigId:     resp.Id,
+		Error:      tsigErr,
+	})
+	buf, err := resp.Pack()
+	if err != nil {
+		log.Errorf("Failed to pack TSIG error response: %v", err)
+		return
+	}
+	w.Write(buf)
+}
+
+// tsigResponseWriter adds a TSIG record to responses so that the server signs them.
+type tsigResponseWriter struct {
+	dns.ResponseWriter
+	req     *dns.Msg
+	reqTsig *dns.TSIG
+}
+
+// WriteMsg implements dns.ResponseWriter.
+func (w *tsigResponseWriter) WriteMsg(m *dns.Msg) error {
+	if m.IsTsig() == nil {
+		// The OPT record has to be in place before the TSIG record is appended.
+		state := request.Request{Req: w.req, W: w.ResponseWriter}
+		state.SizeAndDo(m)
+
+		m.SetTsig(w.reqTsig.Hdr.Name, w.reqTsig.Algorithm, TsigFudge, time.Now().Unix())
+		m.IsTsig().Error = w.reqTsig.Error
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
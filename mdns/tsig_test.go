@@ -0,0 +1,185 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/networkservicemesh/fanout"
+)
+
+const (
+	testTsigSecret  = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+	otherTsigSecret = "b3RoZXItb3RoZXItb3RoZXI="
+)
+
+// startTsigPeer starts a UDP DNS server which answers every query with an A
+// record. When signed is true, the server is wrapped in a tsigHandler using secret.
+func startTsigPeer(t *testing.T, signed bool, secret string) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	var handler plugin.Handler = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		resp := new(dns.Msg).SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 10.0.0.1")
+		resp.Answer = append(resp.Answer, rr)
+		w.WriteMsg(resp)
+		return dns.RcodeSuccess, nil
+	})
+	if signed {
+		handler = &tsigHandler{Next: handler}
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn: pc,
+		TsigSecret: map[string]string{"mesh.": secret},
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			handler.ServeDNS(context.Background(), w, r)
+		}),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestTsigClient(t *testing.T) {
+	key := &tsigKey{name: "mesh.", secret: testTsigSecret, algorithm: dns.HmacSHA256}
+
+	testCases := []struct {
+		name        string
+		signed      bool
+		peerSecret  string
+		expectError bool
+	}{
+		{name: "signed response", signed: true, peerSecret: testTsigSecret},
+		{name: "unsigned response", signed: false, peerSecret: testTsigSecret, expectError: true},
+		{name: "bad signature", signed: true, peerSecret: otherTsigSecret, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := startTsigPeer(t, tc.signed, tc.peerSecret)
+			client := newTsigClient(addr, fanout.UDP, key)
+
+			req := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+			resp, err := client.Request(context.Background(), &request.Request{Req: req, W: &test.ResponseWriter{}})
+
+			if tc.expectError {
+				if err == nil {
+					t.Fatal("Expected an error, but got none")
+				}
+				if !tc.signed && !errors.Is(err, errUnsignedResponse) {
+					t.Errorf("Unexpected error: got %v, want %v", err, errUnsignedResponse)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if len(resp.Answer) != 1 {
+				t.Errorf("Unexpected answer count: got %d, want 1", len(resp.Answer))
+			}
+			if resp.IsTsig() != nil {
+				t.Error("Expected the TSIG record to be stripped from the response")
+			}
+			if req.IsTsig() != nil {
+				t.Error("Expected the original request to be left unsigned")
+			}
+		})
+	}
+}
+
+func TestCheckTsigOrder(t *testing.T) {
+	testCases := []struct {
+		name        string
+		directives  []string
+		expectError bool
+	}{
+		{name: "early", directives: []string{"bind", "errors", "log", AdvertisePluginName, "cache", "forward"}},
+		{name: "after cache", directives: []string{"log", "cache", AdvertisePluginName}, expectError: true},
+		{name: "after the forward plugin", directives: []string{ForwardPluginName, AdvertisePluginName}, expectError: true},
+		{name: "not compiled in", directives: []string{"cache", "forward"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkTsigOrder(tc.directives)
+			if tc.expectError != (err != nil) {
+				t.Errorf("Expected error: %t, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestTsigHandlerErrors(t *testing.T) {
+	addr := startTsigPeer(t, true, testTsigSecret)
+
+	testCases := []struct {
+		name     string
+		keyName  string
+		secret   string
+		expected uint16
+	}{
+		{name: "unknown key", keyName: "other.", secret: testTsigSecret, expected: dns.RcodeBadKey},
+		{name: "bad signature", keyName: "mesh.", secret: otherTsigSecret, expected: dns.RcodeBadSig},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+			req.SetTsig(tc.keyName, dns.HmacSHA256, TsigFudge, time.Now().Unix())
+			buf, _, err := dns.TsigGenerate(req, tc.secret, "", false)
+			if err != nil {
+				t.Fatalf("Failed to sign the query: %v", err)
+			}
+
+			conn, err := dns.Dial("udp", addr)
+			if err != nil {
+				t.Fatalf("Failed to dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := conn.Write(buf); err != nil {
+				t.Fatalf("Failed to send the query: %v", err)
+			}
+			// Read the raw response, as the connection would try to verify it.
+			raw, err := conn.ReadMsgHeader(nil)
+			if err != nil {
+				t.Fatalf("Failed to read the response: %v", err)
+			}
+			resp := new(dns.Msg)
+			if err := resp.Unpack(raw); err != nil {
+				t.Fatalf("Failed to unpack the response: %v", err)
+			}
+
+			if resp.Rcode != dns.RcodeNotAuth {
+				t.Errorf("Unexpected rcode: got %s, want NOTAUTH", dns.RcodeToString[resp.Rcode])
+			}
+			tsigRR := resp.IsTsig()
+			if tsigRR == nil {
+				t.Fatal("Expected a TSIG record carrying the error")
+			}
+			if tsigRR.Error != tc.expected {
+				t.Errorf("Unexpected TSIG error: got %d, want %d", tsigRR.Error, tc.expected)
+			}
+			if tsigRR.MACSize != 0 || tsigRR.MAC != "" {
+				t.Errorf("Expected the error response to be unsigned, got MAC %q", tsigRR.MAC)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package mdns
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/networkservicemesh/fanout"
+)
+
+// ttlClient is a fanout.Client which clamps the TTLs of a peer's responses.
+type ttlClient struct {
+	fanout.Client
+	minTTL uint32
+	maxTTL uint32
+	expiry func() time.Time // when the answering peer expires, nil to not cap at its lifetime
+}
+
+// Request implements fanout.Client.
+func (c *ttlClient) Request(ctx context.Context, r *request.Request) (*dns.Msg, error) {
+	ret, err := c.Client.Request(ctx, r)
+	if err != nil || ret == nil {
+		return ret, err
+	}
+	c.clamp(ret, time.Now())
+	return ret, nil
+}
+
+// clamp limits the TTLs to [minTTL, maxTTL], then caps them at the remaining
+// lifetime of the peer, so that caches do not outlive the peer's advertisement.
+func (c *ttlClient) clamp(m *dns.Msg, now time.Time) {
+	lifetime, capped := uint32(0), false
+	if c.expiry != nil {
+		if expiry := c.expiry(); !expiry.IsZero() {
+			lifetime, capped = uint32(max(expiry.Sub(now)/time.Second, 0)), true
+		}
+	}
+
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT || hdr.Rrtype == dns.TypeTSIG {
+				continue
+			}
+			if c.maxTTL > 0 && hdr.Ttl > c.maxTTL {
+				hdr.Ttl = c.maxTTL
+			}
+			if hdr.Ttl < c.minTTL {
+				hdr.Ttl = c.minTTL
+			}
+			if capped && hdr.Ttl > lifetime {
+				hdr.Ttl = lifetime
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package mdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestTTLClientClamp(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	testCases := []struct {
+		name     string
+		client   ttlClient
+		ttls     []uint32
+		expected []uint32
+	}{
+		{
+			name:     "min and max",
+			client:   ttlClient{minTTL: 10, maxTTL: 300},
+			ttls:     []uint32{0, 60, 3600},
+			expected: []uint32{10, 60, 300},
+		},
+		{
+			name:     "peer lifetime",
+			client:   ttlClient{expiry: func() time.Time { return now.Add(90 * time.Second) }},
+			ttls:     []uint32{30, 3600},
+			expected: []uint32{30, 90},
+		},
+		{
+			name:     "peer lifetime wins over min",
+			client:   ttlClient{minTTL: 120, expiry: func() time.Time { return now.Add(90 * time.Second) }},
+			ttls:     []uint32{30},
+			expected: []uint32{90},
+		},
+		{
+			name:     "expired peer",
+			client:   ttlClient{expiry: func() time.Time { return now.Add(-time.Second) }},
+			ttls:     []uint32{3600},
+			expected: []uint32{0},
+		},
+		{
+			name:     "unknown peer",
+			client:   ttlClient{expiry: func() time.Time { return time.Time{} }},
+			ttls:     []uint32{3600},
+			expected: []uint32{3600},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := new(dns.Msg)
+			for _, ttl := range tc.ttls {
+				m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: "a.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}})
+			}
+			m.SetEdns0(4096, false)
+
+			tc.client.clamp(m, now)
+
+			for idx, rr := range m.Answer {
+				if rr.Header().Ttl != tc.expected[idx] {
+					t.Errorf("Unexpected TTL for record %d: got %d, want %d", idx, rr.Header().Ttl, tc.expected[idx])
+				}
+			}
+			if opt := m.IsEdns0(); opt == nil || opt.Hdr.Ttl != 0 {
+				t.Errorf("Expected the OPT record to be left alone, got %v", opt)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package mdns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// txtEntry formats a DNS-SD key/value pair for a TXT record.
+func txtEntry(key, value string) string {
+	return key + "=" + value
+}
+
+// txtValue returns the value of a key in a DNS-SD TXT record.
+func txtValue(text []string, key string) (string, bool) {
+	for _, entry := range text {
+		k, v, _ := strings.Cut(entry, "=")
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// replaceTxt returns the entries with the entry for key replaced, or appended
+// if there is none. Keys are compared case-insensitively.
+func replaceTxt(text []string, key, entry string) []string {
+	replaced := make([]string, 0, len(text)+1)
+	found := false
+	for _, e := range text {
+		k, _, _ := strings.Cut(e, "=")
+		if !strings.EqualFold(k, key) {
+			replaced = append(replaced, e)
+		} else if !found {
+			replaced = append(replaced, entry)
+			found = true
+		}
+	}
+	if !found {
+		replaced = append(replaced, entry)
+	}
+	return replaced
+}
+
+// removeTxt returns the entries without the entry for key.
+func removeTxt(text []string, key string) []string {
+	removed := make([]string, 0, len(text))
+	for _, e := range text {
+		if k, _, _ := strings.Cut(e, "="); !strings.EqualFold(k, key) {
+			removed = append(removed, e)
+		}
+	}
+	return removed
+}
+
+// DNS-SD TXT record size limits, see RFC 6763 section 6.
+const (
+	MaxTxtEntrySize  = 255  // a single key=value string
+	MaxTxtRecordSize = 1300 // the whole record, so that it fits in a single packet
+)
+
+// reservedTxtKeys are published by the mesh itself and cannot be configured.
+var reservedTxtKeys = []string{TxtMesh, TxtNodeID, TxtTimestamp, TxtSignature, TxtSubnets, TxtZones, TxtListen}
+
+// validateTxtEntry checks that a configured key/value pair is a valid DNS-SD
+// TXT entry and does not use a key reserved by the mesh.
+func validateTxtEntry(key, value string) error {
+	if key == "" {
+		return errors.New("txt key is empty")
+	}
+	for _, c := range []byte(key) {
+		if c < 0x20 || c > 0x7e || c == '=' {
+			return fmt.Errorf("txt key %q must be printable ASCII without '='", key)
+		}
+	}
+	for _, reserved := range reservedTxtKeys {
+		if strings.EqualFold(key, reserved) {
+			return fmt.Errorf("txt key %q is reserved by the mesh", key)
+		}
+	}
+	if size := len(txtEntry(key, value)); size > MaxTxtEntrySize {
+		return fmt.Errorf("txt entry for key %q is %d bytes, at most %d are allowed", key, size, MaxTxtEntrySize)
+	}
+	return nil
+}
+
+// validateTxtRecord checks the size of a complete TXT record.
+func validateTxtRecord(text []string) error {
+	size := 0
+	for _, entry := range text {
+		if len(entry) > MaxTxtEntrySize {
+			key, _, _ := strings.Cut(entry, "=")
+			return fmt.Errorf("txt entry for key %q is %d bytes, at most %d are allowed", key, len(entry), MaxTxtEntrySize)
+		}
+		size += 1 + len(entry) // length byte and data
+	}
+	if size > MaxTxtRecordSize {
+		return fmt.Errorf("txt record is %d bytes, at most %d are allowed", size, MaxTxtRecordSize)
+	}
+	return nil
+}
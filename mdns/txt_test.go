@@ -0,0 +1,105 @@
+package mdns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTxtEntry(t *testing.T) {
+	testCases := []struct {
+		name  string
+		key   string
+		value string
+		valid bool
+	}{
+		{name: "valid", key: "role", value: "prod", valid: true},
+		{name: "empty value", key: "flag", value: "", valid: true},
+		{name: "empty key", key: "", value: "x"},
+		{name: "key with equals", key: "a=b", value: "x"},
+		{name: "key with control character", key: "a\tb", value: "x"},
+		{name: "reserved key", key: "node_id", value: "x"},
+		{name: "reserved key in other case", key: "Zones", value: "x"},
+		{name: "entry at the limit", key: "k", value: strings.Repeat("x", MaxTxtEntrySize-2), valid: true},
+		{name: "entry too large", key: "k", value: strings.Repeat("x", MaxTxtEntrySize-1)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateTxtEntry(tc.key, tc.value)
+			if tc.valid && err != nil {
+				t.Errorf("Expected no error, but got: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("Expected an error, but got none")
+			}
+		})
+	}
+}
+
+func TestValidateTxtRecord(t *testing.T) {
+	entry := "k=" + strings.Repeat("x", 198) // 200 bytes, 201 on the wire
+
+	if err := validateTxtRecord([]string{entry, entry, entry, entry, entry, entry}); err != nil {
+		t.Errorf("Expected a 1206 byte record to be valid, got: %v", err)
+	}
+	if err := validateTxtRecord([]string{entry, entry, entry, entry, entry, entry, entry}); err == nil {
+		t.Error("Expected a 1407 byte record to be rejected")
+	}
+	if err := validateTxtRecord([]string{"zones=" + strings.Repeat("x", MaxTxtEntrySize)}); err == nil {
+		t.Error("Expected an oversized entry to be rejected")
+	}
+}
+
+func TestSetTxt(t *testing.T) {
+	a := NewMdnsAdvertise("instance", "_test._udp", 53, 60)
+	for _, entry := range []string{"role=prod", "flag"} {
+		if err := a.AddTxt(entry); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	if err := a.SetTxt("serial", "1"); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if err := a.SetTxt("Serial", "2"); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if err := a.AddTxt("role=staging"); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	expected := []string{"role=staging", "flag", "Serial=2"}
+	if !reflect.DeepEqual(a.txtEntries, expected) {
+		t.Fatalf("Expected %v, got %v", expected, a.txtEntries)
+	}
+
+	a.RemoveTxt("flag")
+	a.RemoveTxt("unknown")
+	expected = []string{"role=staging", "Serial=2"}
+	if !reflect.DeepEqual(a.txtEntries, expected) {
+		t.Fatalf("Expected %v, got %v", expected, a.txtEntries)
+	}
+
+	if err := a.SetTxt("node_id", "x"); err == nil {
+		t.Error("Expected a reserved key to be rejected")
+	}
+	for _, entry := range []string{"NODE_ID=x", "=x", "big=" + strings.Repeat("x", MaxTxtEntrySize)} {
+		if err := a.AddTxt(entry); err == nil {
+			t.Errorf("Expected %.20q to be rejected", entry)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		if err := a.AddTxt(string(rune('a'+i)) + "=" + strings.Repeat("x", 200)); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+	if err := a.SetTxt("big", strings.Repeat("x", 200)); err == nil {
+		t.Error("Expected an oversized record to be rejected")
+	}
+	if err := a.AddTxt("big=" + strings.Repeat("x", 200)); err == nil {
+		t.Error("Expected an oversized record to be rejected")
+	}
+	if _, ok := txtValue(a.txtEntries, "big"); ok {
+		t.Error("Expected a rejected entry to not be kept")
+	}
+}